@@ -0,0 +1,526 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/spf13/viper"
+)
+
+// apiServer is the HTTP JSON API doing the same as the Telegram commands
+type apiServer struct {
+	db     *bolt.DB
+	runner *taskRunner
+	token  string
+}
+
+// apiEnabled reports whether api.listen is set
+func apiEnabled() bool {
+	return viper.GetString("api.listen") != ""
+}
+
+// startAPI serves the API on api.listen, nothing is served when it is empty or api.token is not set
+func startAPI(db *bolt.DB, runner *taskRunner) {
+	listen := viper.GetString("api.listen")
+	if listen == "" {
+		return
+	}
+
+	token := viper.GetString("api.token")
+	if token == "" {
+		logger.Warn("api.token is not set, the API is disabled")
+		return
+	}
+
+	s := &apiServer{db: db, runner: runner, token: token}
+
+	go func() {
+		logger.WithField("listen", listen).Info("API is served on /api/")
+		if err := http.ListenAndServe(listen, s.handler()); err != nil {
+			logger.WithError(err).Error("API server stopped")
+		}
+	}()
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tasks", s.handleTasks)
+	mux.HandleFunc("/api/tasks/", s.handleTask)
+	mux.HandleFunc("/api/progress", s.handleProgress)
+	mux.HandleFunc("/api/stats", s.handleStats)
+	mux.HandleFunc("/api/limits", s.handleLimits)
+	mux.HandleFunc("/api/budget", s.handleBudget)
+	mux.HandleFunc("/api/cooldown", s.handleCooldown)
+	mux.HandleFunc("/api/watch", s.handleWatch)
+	mux.HandleFunc("/api/queue", s.handleQueue)
+	mux.HandleFunc("/api/audit", s.handleAudit)
+	for _, name := range listNames {
+		mux.HandleFunc("/api/"+name, s.handleList(name))
+	}
+	mux.HandleFunc("/", s.handleDashboard)
+
+	root := http.NewServeMux()
+	root.HandleFunc("/login", s.handleLogin)
+	root.Handle("/", s.auth(mux))
+	return root
+}
+
+// auth accepts requests with "Authorization: Bearer <api.token>" and requests of the dashboard
+func (s *apiServer) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			if r.URL.Path == "/" {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) authorized(r *http.Request) bool {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return s.validToken(strings.TrimPrefix(header, "Bearer "))
+	}
+
+	// browsers send the cookie with forms of other sites too, so changes also need a header they can't add
+	cookie, err := r.Cookie(dashboardCookie)
+	if err != nil || !s.validToken(cookie.Value) {
+		return false
+	}
+	return r.Method == http.MethodGet || r.Header.Get(dashboardHeader) != ""
+}
+
+func (s *apiServer) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.WithError(err).Debug("can't write API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+}
+
+// readJSON decodes the request body into value, an empty body leaves value as is
+func readJSON(r *http.Request, value interface{}) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		return fmt.Errorf("invalid request body: %s", err)
+	}
+	return nil
+}
+
+// apiTask is taskStatus as JSON
+type apiTask struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func newAPITask(status taskStatus) apiTask {
+	task := apiTask{Name: status.Name, State: status.State.String()}
+	if !status.StartedAt.IsZero() {
+		startedAt := status.StartedAt
+		task.StartedAt = &startedAt
+	}
+	if status.LastError != nil {
+		task.LastError = status.LastError.Error()
+	}
+	return task
+}
+
+// handleTasks lists the tasks: GET /api/tasks
+func (s *apiServer) handleTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	tasks := make([]apiTask, 0)
+	for _, status := range s.runner.List() {
+		tasks = append(tasks, newAPITask(status))
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// handleTask starts or cancels a task: POST /api/tasks/<name>/start {"arg": "..."}, POST /api/tasks/<name>/cancel
+func (s *apiServer) handleTask(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/tasks/"), "/")
+	name := s.taskName(parts[0])
+	if name == "" {
+		writeError(w, http.StatusNotFound, errTaskNotFound)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		status, err := s.runner.Status(name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPITask(status))
+		return
+	}
+
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	switch parts[1] {
+	case "start":
+		var body struct {
+			Arg string `json:"arg"`
+		}
+		if err := readJSON(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if body.Arg == "" {
+			switch name {
+			case "refollow":
+				writeError(w, http.StatusBadRequest, fmt.Errorf("arg must be a username"))
+				return
+			case "followLikers":
+				writeError(w, http.StatusBadRequest, fmt.Errorf("arg must be a post link"))
+				return
+			case "followQueue":
+				body.Arg = "100"
+			}
+		}
+
+		err := s.runner.Start(name, body.Arg)
+		if err == errTaskRunning {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		l.Lock()
+		editMessage[name] = make(map[int]int)
+		l.Unlock()
+	case "cancel":
+		if !s.runner.Cancel(name) {
+			writeError(w, http.StatusConflict, fmt.Errorf("%s is not running", name))
+			return
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	status, _ := s.runner.Status(name)
+	writeJSON(w, http.StatusAccepted, newAPITask(status))
+}
+
+// taskName finds a registered task ignoring case, so followlikers works as in Telegram
+func (s *apiServer) taskName(name string) string {
+	for _, status := range s.runner.List() {
+		if strings.EqualFold(status.Name, name) {
+			return status.Name
+		}
+	}
+	return ""
+}
+
+// handleProgress returns the progress of the tasks: GET /api/progress
+func (s *apiServer) handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	l.RLock()
+	progress := make(map[string]int, len(state))
+	for key, value := range state {
+		progress[key] = value
+	}
+	l.RUnlock()
+
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// apiDayStats is dayStats as JSON
+type apiDayStats struct {
+	Day    string                    `json:"day"`
+	Counts map[string]map[string]int `json:"counts"`
+}
+
+// handleStats returns the stats by day: GET /api/stats?range=7d, the range is today by default
+func (s *apiServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	rangeArgs := r.URL.Query().Get("range")
+	if rangeArgs == "" {
+		rangeArgs = "today"
+	}
+	from, to, err := parseStatsRange(rangeArgs, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	days, err := getStatsRange(s.db, from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	result := make([]apiDayStats, 0, len(days))
+	for _, day := range days {
+		result = append(result, apiDayStats{Day: day.Day.Format("2006-01-02"), Counts: day.Counts})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleList manages a list: GET, GET ?history=1 with who changed the items, POST {"items": [...]} adds, DELETE {"items": [...]} removes
+func (s *apiServer) handleList(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Items []string `json:"items"`
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Query().Get("history") != "" {
+				items, err := getListItems(s.db, name)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				if items == nil {
+					items = []listItem{}
+				}
+				writeJSON(w, http.StatusOK, items)
+				return
+			}
+		case http.MethodPost, http.MethodDelete:
+			if err := readJSON(r, &body); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if len(body.Items) == 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("items are empty"))
+				return
+			}
+			if _, err := changeList(s.db, name, body.Items, r.Method == http.MethodDelete, "api"); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		default:
+			methodNotAllowed(w, r)
+			return
+		}
+
+		list, err := getList(s.db, name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// handleLimits returns the limits or changes them: PUT {"like.count": 10}
+func (s *apiServer) handleLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body map[string]json.Number
+		if err := readJSON(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		values := make(map[string]string, len(body))
+		for limit, value := range body {
+			values[limit] = value.String()
+		}
+		if err := setLimits(values); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, getLimitValues())
+}
+
+func (s *apiServer) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	now := time.Now()
+	budget := make(map[string]interface{}, len(budgetActions))
+	for _, action := range budgetActions {
+		usage, err := getBudgetUsage(s.db, action)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		usage = usage.at(now)
+		limits := getActionBudget(action)
+
+		budget[action] = map[string]interface{}{
+			"per_hour":   limits.PerHour,
+			"per_day":    limits.PerDay,
+			"spacing":    limits.Spacing.String(),
+			"jitter":     limits.Jitter.String(),
+			"hour_count": usage.HourCount,
+			"day_count":  usage.DayCount,
+			"next":       usage.Next,
+		}
+	}
+	writeJSON(w, http.StatusOK, budget)
+}
+
+func (s *apiServer) handleCooldown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		if err := clearCooldown(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+
+	current, active := getCurrentCooldown()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active": active,
+		"until":  current.Until,
+		"class":  current.Class,
+		"error":  current.Error,
+	})
+}
+
+// handleWatch manages the watch list: GET, POST {"username": "..."} adds, DELETE {"username": "..."} removes
+func (s *apiServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		if err := readJSON(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if body.Username == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("username is empty"))
+			return
+		}
+
+		var err error
+		if r.Method == http.MethodPost {
+			if err = setWatching(s.db, body.Username); err != nil {
+				writeError(w, http.StatusConflict, fmt.Errorf("already watching %s", body.Username))
+				return
+			}
+		} else {
+			err = deleteKeyFromBucket(s.db, "watching", body.Username)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+
+	list, err := getWatchingList(s.db)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if list == nil {
+		list = []string{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleQueue returns the size and the first users of the follow queue: GET /api/queue?limit=50
+func (s *apiServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+
+	users := getUsersFromQueue(s.db, limit)
+	if users == nil {
+		users = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"size":  bucketStats(s.db, "followqueue").KeyN,
+		"users": users,
+	})
+}
+
+// handleAudit returns the latest audit entries, newest first: GET /api/audit?limit=50
+func (s *apiServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > auditMaxLimit {
+		limit = auditDefaultLimit
+	}
+
+	entries, err := getAudit(s.db, limit, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if entries == nil {
+		entries = []auditEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// useTestConfig loads a copy of dist/config.json, the returned func removes it and resets viper
+func useTestConfig(t *testing.T) func() {
+	data, err := ioutil.ReadFile("dist/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "instabot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	setConfigDefaults(viper.GetViper())
+	validConfig = data
+
+	return func() {
+		viper.Reset()
+		os.RemoveAll(dir)
+	}
+}
+
+func putLimits(s *apiServer, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handleLimits(w, httptest.NewRequest(http.MethodPut, "/api/limits", strings.NewReader(body)))
+	return w
+}
+
+func TestAPIPutLimits(t *testing.T) {
+	defer useTestConfig(t)()
+	viper.SetDefault("notify.telegram.timeout", "30s")
+	s := &apiServer{}
+
+	if w := putLimits(s, `{"like.count": 7, "follow.count": 20000}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid limit: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if viper.GetInt("limits.like.count") != 20 {
+		t.Errorf("like.count = %d after a rejected request, want 20", viper.GetInt("limits.like.count"))
+	}
+
+	if w := putLimits(s, `{"like.count": 7, "follow.potency_ratio": 1.5}`); w.Code != http.StatusOK {
+		t.Fatalf("valid limits: status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	data, err := ioutil.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := viper.New()
+	written.SetConfigType("json")
+	if err := written.ReadConfig(strings.NewReader(string(data))); err != nil {
+		t.Fatal(err)
+	}
+	if written.GetInt("limits.like.count") != 7 || written.GetFloat64("limits.follow.potency_ratio") != 1.5 {
+		t.Errorf("written limits: like.count %d, potency_ratio %v", written.GetInt("limits.like.count"), written.GetFloat64("limits.follow.potency_ratio"))
+	}
+	if written.IsSet("notify.telegram.timeout") {
+		t.Error("the default notify.telegram.timeout was written to the config file")
+	}
+}
+
+func TestReloadConfigRejectsInvalidFile(t *testing.T) {
+	defer useTestConfig(t)()
+
+	changed := make(chan struct{}, 10)
+	if err := watchConfig(func(e fsnotify.Event) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := validConfig
+	invalid := strings.Replace(string(valid), `"count": 20`, `"count": "many"`, 1)
+	if err := ioutil.WriteFile(viper.ConfigFileUsed(), []byte(invalid), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change is not noticed")
+	}
+
+	if err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "limits.like.count") {
+		t.Errorf("invalid file: got %v, want the like count rejected", err)
+	}
+	if viper.GetInt("limits.like.count") != 20 || string(validConfig) != string(valid) {
+		t.Errorf("like.count = %v after a rejected file, want 20", viper.Get("limits.like.count"))
+	}
+}
+
+func TestTelegramTokenRequiredWithoutAPIToken(t *testing.T) {
+	defer useTestConfig(t)()
+	viper.Set("user.telegram.token", "")
+	viper.Set("api.listen", "127.0.0.1:8080")
+
+	if _, err := readConfig(viper.GetViper()); err == nil || !strings.Contains(err.Error(), "user.telegram.token") {
+		t.Errorf("api.listen without api.token: got %v, want the token required", err)
+	}
+
+	viper.Set("api.token", "secret")
+	if _, err := readConfig(viper.GetViper()); err != nil {
+		t.Errorf("api.listen with api.token: %s", err)
+	}
+}
+
+func TestDashboardLoginRejectsWrongToken(t *testing.T) {
+	s := &apiServer{token: "secret"}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("token=wrong"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.handleLogin(w, r)
+
+	result := w.Result()
+	if result.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", result.StatusCode, http.StatusUnauthorized)
+	}
+	if contentType := result.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Errorf("content type %q, want the login page", contentType)
+	}
+}
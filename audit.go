@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// auditEntry is a record of a single Instagram action
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Task   string    `json:"task"`
+	Source string    `json:"source"`
+	Dev    bool      `json:"dev,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (e auditEntry) String() string {
+	result := "✓"
+	if e.Error != "" {
+		result = "✗ " + e.Error
+	}
+	if e.Dev {
+		result += " (dev)"
+	}
+	return fmt.Sprintf("%s %s %s by %s %s %s", e.Time.Format("01-02 15:04"), e.Action, e.Target, e.Task, e.Source, result)
+}
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+)
+
+// audit appends the result of an action to the audit log, failures are only logged
+func audit(db *bolt.DB, action, target, task, source string, err error) {
+	entry := auditEntry{
+		Time:   time.Now(),
+		Action: action,
+		Target: target,
+		Task:   task,
+		Source: source,
+		Dev:    *dev,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else if !*dev {
+		countAction(action, task, source)
+	}
+
+	if err := addAudit(db, entry); err != nil {
+		logger.WithFields(logrus.Fields{"task": task, "action": action, "username": target}).WithError(err).Error("can't add audit entry")
+	}
+}
+
+func addAudit(db *bolt.DB, entry auditEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode audit entry")
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte("audit"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to get 'audit' bucket")
+		}
+
+		seq, err := bk.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+
+		return bk.Put(key, value)
+	})
+}
+
+// getAudit returns up to limit latest entries accepted by match, newest first
+func getAudit(db *bolt.DB, limit int, match func(entry auditEntry) bool) ([]auditEntry, error) {
+	var entries []auditEntry
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("audit"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'audit' bucket")
+		}
+
+		c := bk.Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < limit; k, v = c.Prev() {
+			var entry auditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				logger.WithField("key", fmt.Sprintf("%x", k)).WithError(err).Warn("invalid audit entry")
+				continue
+			}
+			if match == nil || match(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// exportAudit writes the entries made from..to as CSV, oldest first
+func exportAudit(db *bolt.DB, from, to time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "action", "target", "task", "source", "dev", "error"})
+
+	end := to.AddDate(0, 0, 1)
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("audit"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'audit' bucket")
+		}
+
+		return bk.ForEach(func(k, v []byte) error {
+			var entry auditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				logger.WithField("key", fmt.Sprintf("%x", k)).WithError(err).Warn("invalid audit entry")
+				return nil
+			}
+			if entry.Time.Before(from) || !entry.Time.Before(end) {
+				return nil
+			}
+			return w.Write([]string{
+				entry.Time.Format(time.RFC3339),
+				entry.Action,
+				entry.Target,
+				entry.Task,
+				entry.Source,
+				strconv.FormatBool(entry.Dev),
+				entry.Error,
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// sendAudit handles /audit last N, /audit user name [N] and /audit export [range]
+func sendAudit(bot Messenger, db *bolt.DB, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+	msg.DisableWebPagePreview = true
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fields = []string{"last"}
+	}
+
+	limit := auditDefaultLimit
+	var match func(entry auditEntry) bool
+
+	switch {
+	case fields[0] == "last" && len(fields) <= 2:
+		if len(fields) == 2 {
+			limit, _ = strconv.Atoi(fields[1])
+		}
+	case fields[0] == "user" && (len(fields) == 2 || len(fields) == 3):
+		username := strings.TrimPrefix(fields[1], "@")
+		match = func(entry auditEntry) bool { return strings.EqualFold(entry.Target, username) }
+		if len(fields) == 3 {
+			limit, _ = strconv.Atoi(fields[2])
+		}
+	case fields[0] == "export":
+		rangeArgs := "month"
+		if len(fields) > 1 {
+			rangeArgs = strings.Join(fields[1:], " ")
+		}
+		from, to, err := parseStatsRange(rangeArgs, time.Now())
+		if err != nil {
+			msg.Text = err.Error()
+			bot.Send(msg)
+			return
+		}
+
+		data, err := exportAudit(db, from, to)
+		if err == nil {
+			name := fmt.Sprintf("audit-%s-%s.csv", from.Format("20060102"), to.Format("20060102"))
+			err = bot.SendDocument(userID, name, data)
+		}
+		if err != nil {
+			msg.Text = fmt.Sprintf("export failed: %s", err)
+			bot.Send(msg)
+		}
+		return
+	default:
+		msg.Text = "/audit last [N] | user name [N] | export [7d | month | 2006-01-02..2006-01-31]"
+		bot.Send(msg)
+		return
+	}
+
+	if limit <= 0 || limit > auditMaxLimit {
+		limit = auditDefaultLimit
+	}
+
+	entries, err := getAudit(db, limit, match)
+	if err != nil {
+		msg.Text = err.Error()
+		bot.Send(msg)
+		return
+	}
+	if len(entries) == 0 {
+		msg.Text = "No actions found"
+		bot.Send(msg)
+		return
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+	}
+	for _, text := range splitMessage(lines, telegramMessageLimit) {
+		msg.Text = text
+		bot.Send(msg)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// backupPrefix starts the names of the scheduled backups, rotation only touches these files
+const backupPrefix = "instabot-"
+
+// snapshotBuckets are the buckets a snapshot must have, by the schema version which created them
+var snapshotBuckets = map[int][]string{
+	1: {"stats", "followed", "watching", "followqueue"},
+	4: {"audit", "sessions"},
+	5: {"budget"},
+	6: {"cooldown"},
+	7: {"tags", "comments", "whitelist"},
+	8: {"tagsettings"},
+}
+
+// snapshot returns a consistent copy of the database
+func snapshot(db *bolt.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	err := db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&buf)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to snapshot database")
+	}
+	return buf.Bytes(), nil
+}
+
+// sendBackup sends a snapshot of the database as a document
+func sendBackup(bot Messenger, db *bolt.DB, userID int64) {
+	data, err := snapshot(db)
+	if err == nil {
+		name := fmt.Sprintf("%s%s.db", backupPrefix, time.Now().Format("20060102-150405"))
+		err = bot.SendDocument(userID, name, data)
+	}
+	if err != nil {
+		msg := tgbotapi.NewMessage(userID, fmt.Sprintf("backup failed: %s", err))
+		bot.Send(msg)
+	}
+}
+
+// writeBackup saves a snapshot to the backup dir and removes the oldest ones over backup.keep
+func writeBackup(db *bolt.DB) (string, error) {
+	dir := viper.GetString("backup.dir")
+	keep := viper.GetInt("backup.keep")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s%s.db", backupPrefix, time.Now().Format("20060102-150405")))
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path+".tmp", 0600)
+	})
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return "", errors.Wrapf(err, "failed to write backup")
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return "", err
+	}
+
+	return path, rotateBackups(dir, keep)
+}
+
+func rotateBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, backupPrefix+"*.db"))
+	if err != nil {
+		return err
+	}
+	// names end with the time, so the oldest go first
+	sort.Strings(files)
+
+	for len(files) > keep {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		logger.WithField("path", files[0]).Info("removed old backup")
+		files = files[1:]
+	}
+	return nil
+}
+
+// backupJob is the scheduled backup, failures are reported to admins
+func backupJob(db *bolt.DB) {
+	path, err := writeBackup(db)
+	if err != nil {
+		notify(severityWarning, "", fmt.Sprintf("scheduled backup failed: %s", err))
+		return
+	}
+	logger.WithField("path", path).Info("database backup saved")
+}
+
+// validateSnapshot checks that path is a bolt database with the buckets of its schema version
+func validateSnapshot(path string) (int, error) {
+	snap, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer snap.Close()
+
+	var version int
+	err = snap.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return errors.Wrapf(err, "snapshot is corrupted")
+		}
+
+		version = getSchemaVersion(tx)
+		if version > schemaVersion() {
+			return fmt.Errorf("snapshot schema version %d is newer than supported %d", version, schemaVersion())
+		}
+
+		var missing []string
+		for created, buckets := range snapshotBuckets {
+			if created > version && created > 1 {
+				continue
+			}
+			for _, name := range buckets {
+				if tx.Bucket([]byte(name)) == nil {
+					missing = append(missing, name)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return fmt.Errorf("snapshot has no buckets %s", strings.Join(missing, ", "))
+		}
+		return nil
+	})
+	return version, err
+}
+
+// restoreDatabase replaces the database with the snapshot at path, the bot must be stopped.
+// The replaced database is kept next to it.
+func restoreDatabase(path string) error {
+	if path == "" {
+		return fmt.Errorf("usage: go-instabot restore path/to/snapshot.db")
+	}
+
+	version, err := validateSnapshot(path)
+	if err != nil {
+		return err
+	}
+
+	// bolt locks the file, so this fails while the bot is running
+	live, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "failed to lock %s, is the bot stopped?", dbPath)
+	}
+	defer live.Close()
+
+	previous := fmt.Sprintf("%s.before-restore-%s.bak", dbPath, time.Now().Format("20060102-150405"))
+	err = live.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(previous, 0600)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to save current database")
+	}
+	logger.WithField("path", previous).Info("current database saved")
+
+	if err := copyFile(path, dbPath+".tmp"); err != nil {
+		return err
+	}
+	if err := os.Rename(dbPath+".tmp", dbPath); err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{"path": path, "version": version}).Info("database restored")
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
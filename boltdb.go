@@ -1,116 +1,259 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ahmdrz/goinsta/v2"
 	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-func initBolt() (db *bolt.DB, error error) {
-	db, err := bolt.Open("instabot.db", 0600, &bolt.Options{Timeout: 5 * time.Second})
-	if err != nil {
-		return
-	}
+// dbPath is the bolt database file
+var dbPath = "instabot.db"
 
-	tx, err := db.Begin(true)
+// initBolt opens the database and brings its schema to the latest version
+func initBolt() (*bolt.DB, error) {
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: 5 * time.Second})
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer tx.Rollback()
 
-	// Setup the stats bucket.
-	_, err = tx.CreateBucketIfNotExists([]byte("stats"))
-	if err != nil {
-		return
+	if err := migrate(db, *migrateDryRun); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	// Setup the followed bucket.
-	_, err = tx.CreateBucketIfNotExists([]byte("followed"))
-	if err != nil {
-		return
-	}
+	return db, nil
+}
 
-	// Setup the watching bucket.
-	_, err = tx.CreateBucketIfNotExists([]byte("watching"))
-	if err != nil {
-		return
-	}
+// statsDayFormat names the per day buckets inside the stats bucket
+const statsDayFormat = "20060102"
 
-	// Setup the followqueue bucket.
-	_, err = tx.CreateBucketIfNotExists([]byte("followqueue"))
-	if err != nil {
-		return
+// dayStats holds the counters of a day by action and source
+type dayStats struct {
+	Day    time.Time
+	Counts map[string]map[string]int
+}
+
+// Count returns the counter of action made by source, source "" sums all sources
+func (s dayStats) Count(action, source string) int {
+	if source != "" {
+		return s.Counts[action][source]
 	}
 
-	if err := tx.Commit(); err != nil {
-		return
+	var count int
+	for _, n := range s.Counts[action] {
+		count += n
 	}
+	return count
+}
 
-	return db, nil
+func statsKey(action, source string) []byte {
+	return []byte(action + "/" + source)
 }
 
-func getStats(db *bolt.DB, id string) (int, error) {
-	d := time.Now().Format("20060102")
-	id = d + id
+func parseStatsKey(key []byte) (action, source string) {
+	parts := strings.SplitN(string(key), "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
 
-	var count int
+// getStats returns today's counter of action made by source, source "" sums all sources
+func getStats(db *bolt.DB, action, source string) (int, error) {
+	today := time.Now()
+	days, err := getStatsRange(db, today, today)
+	if err != nil {
+		return 0, err
+	}
+	return days[0].Count(action, source), nil
+}
+
+// getStatsRange returns the counters of every day from..to, days without actions are empty
+func getStatsRange(db *bolt.DB, from, to time.Time) ([]dayStats, error) {
+	var days []dayStats
 	err := db.View(func(tx *bolt.Tx) error {
 		bk := tx.Bucket([]byte("stats"))
 		if bk == nil {
 			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'stats' bucket")
 		}
 
-		bs := bk.Get([]byte(id))
-		if bs == nil {
-			return errors.Wrapf(fmt.Errorf("key not found"), "failed to find stats for '%s'", id)
+		last := to.Format(statsDayFormat)
+		for day := from; day.Format(statsDayFormat) <= last; day = day.AddDate(0, 0, 1) {
+			stats := dayStats{Day: day, Counts: make(map[string]map[string]int)}
+			days = append(days, stats)
+
+			dayBk := bk.Bucket([]byte(day.Format(statsDayFormat)))
+			if dayBk == nil {
+				continue
+			}
+
+			err := dayBk.ForEach(func(k, v []byte) error {
+				count, err := strconv.Atoi(string(v))
+				if err != nil {
+					return errors.Wrapf(fmt.Errorf("stat count is not a number"), "invalid stat value for '%s' at %s", k, day.Format(statsDayFormat))
+				}
+
+				action, source := parseStatsKey(k)
+				if stats.Counts[action] == nil {
+					stats.Counts[action] = make(map[string]int)
+				}
+				stats.Counts[action][source] += count
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return days, err
+}
+
+// incStats increments today's counter of action made by source
+func incStats(db *bolt.DB, action, source string) error {
+	day := time.Now().Format(statsDayFormat)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte("stats"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to get 'stats' bucket")
+		}
+
+		dayBk, err := bk.CreateBucketIfNotExists([]byte(day))
+		if err != nil {
+			return errors.Wrapf(err, "failed to get stats bucket for %s", day)
 		}
 
+		return addStats(dayBk, statsKey(action, source), 1)
+	})
+	return err
+}
+
+func addStats(bk *bolt.Bucket, key []byte, n int) error {
+	var count int
+
+	bs := bk.Get(key)
+	if bs != nil {
 		var err error
 		count, err = strconv.Atoi(string(bs))
 		if err != nil {
-			return errors.Wrapf(fmt.Errorf("stat count is not a number"), "invalid stat value for '%s'", id)
+			return errors.Wrapf(fmt.Errorf("stat count is not a number"), "invalid stat value for '%s'", key)
 		}
+	}
 
-		return nil
-	})
-	return count, err
+	return bk.Put(key, []byte(strconv.Itoa(count+n)))
 }
 
-func incStats(db *bolt.DB, id string) error {
-	d := time.Now().Format("20060102")
-	id = d + id
+// legacyStatsKey matches the old flat stats keys like "20061017follow"
+var legacyStatsKey = regexp.MustCompile(`^(\d{8})(.+)$`)
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		bk, err := tx.CreateBucketIfNotExists([]byte("stats"))
+// migrateStats moves the old flat counters into per day buckets.
+// The old "follow" counter also included "refollow" and "followLikers", so only the rest is counted as follows by tag.
+// The old "refollow" counter mixed refollows with follows from the queue, so it is kept under the "legacy" source.
+func migrateStats(tx *bolt.Tx) (int, error) {
+	bk := tx.Bucket([]byte("stats"))
+	if bk == nil {
+		return 0, nil
+	}
+
+	legacy := make(map[string]map[string]int)
+	var keys [][]byte
+	err := bk.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+
+		match := legacyStatsKey.FindSubmatch(k)
+		if match == nil {
+			return nil
+		}
+		count, err := strconv.Atoi(string(v))
 		if err != nil {
-			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'stats' bucket")
+			logger.WithField("key", string(k)).Warn("skip invalid stat value")
+			return nil
 		}
 
-		var count int
+		day := string(match[1])
+		if legacy[day] == nil {
+			legacy[day] = make(map[string]int)
+		}
+		legacy[day][string(match[2])] += count
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
 
-		bs := bk.Get([]byte(id))
-		if bs == nil {
-			fmt.Printf("No previous stats found for '%s'\n", id)
-		} else {
-			count, err = strconv.Atoi(string(bs))
-			if err != nil {
-				return errors.Wrapf(fmt.Errorf("stat count is not a number"), "invalid stat value for '%s'", id)
+	for day, counts := range legacy {
+		dayBk, err := bk.CreateBucketIfNotExists([]byte(day))
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to create stats bucket for %s", day)
+		}
+
+		for id, count := range counts {
+			action, source := id, "legacy"
+			switch id {
+			case "follow":
+				action, source = "follow", "tag"
+				count -= counts["refollow"] + counts["followLikers"]
+			case "refollow":
+				action = "follow"
+			case "followLikers":
+				action, source = "follow", "followlikers"
+			case "like", "comment":
+				source = "tag"
+			case "unfollow":
+				source = "sync"
+			}
+			if count <= 0 {
+				continue
+			}
+
+			if err := addStats(dayBk, statsKey(action, source), count); err != nil {
+				return 0, err
 			}
 		}
+	}
 
-		count = count + 1
-		countStr := strconv.Itoa(count)
+	for _, k := range keys {
+		if err := bk.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
 
-		return bk.Put([]byte(id), []byte(countStr))
-	})
-	return err
+// relationState is the state of our follow of a user
+type relationState string
+
+const (
+	relationRequested  relationState = "requested"
+	relationFollowing  relationState = "following"
+	relationUnfollowed relationState = "unfollowed"
+)
+
+// relationship is a record of the followed bucket
+type relationship struct {
+	FollowedAt   time.Time     `json:"followed_at"`
+	Source       string        `json:"source,omitempty"`
+	SourceDetail string        `json:"source_detail,omitempty"`
+	FollowBackAt time.Time     `json:"follow_back_at"`
+	UnfollowedAt time.Time     `json:"unfollowed_at"`
+	State        relationState `json:"state"`
 }
 
-func getFollowed(db *bolt.DB, id string) (string, error) {
-	var prevDate string
+func getRelationship(db *bolt.DB, id string) (*relationship, error) {
+	var rel *relationship
 	err := db.View(func(tx *bolt.Tx) error {
 		bk := tx.Bucket([]byte("followed"))
 		if bk == nil {
@@ -121,25 +264,193 @@ func getFollowed(db *bolt.DB, id string) (string, error) {
 		if bs == nil {
 			return nil
 		}
-		prevDate = string(bs)
 
+		rel = &relationship{}
+		if err := json.Unmarshal(bs, rel); err != nil {
+			return errors.Wrapf(err, "invalid relationship for '%s'", id)
+		}
 		return nil
 	})
-	return prevDate, err
+	return rel, err
+}
+
+func putRelationship(bk *bolt.Bucket, id string, rel *relationship) error {
+	value, err := json.Marshal(rel)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode relationship for '%s'", id)
+	}
+	return bk.Put([]byte(id), value)
+}
+
+// getFollowed returns the date we followed the user, empty if we never did
+func getFollowed(db *bolt.DB, id string) (string, error) {
+	rel, err := getRelationship(db, id)
+	if err != nil || rel == nil {
+		return "", err
+	}
+	if rel.FollowedAt.IsZero() {
+		return string(rel.State), nil
+	}
+	return rel.FollowedAt.Format("2006-01-02"), nil
+}
+
+// setFollowed records a follow of the user found by source (tag, refollow, followlikers, queue),
+// detail is the tag, username or post url. requested is set for private accounts.
+func setFollowed(db *bolt.DB, id, source, detail string, requested bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("followed"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'followed' bucket")
+		}
+
+		rel := &relationship{FollowedAt: time.Now(), Source: source, SourceDetail: detail, State: relationFollowing}
+		if requested {
+			rel.State = relationRequested
+		}
+		return putRelationship(bk, id, rel)
+	})
+}
+
+// setUnfollowed marks the user unfollowed keeping the follow date
+func setUnfollowed(db *bolt.DB, id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("followed"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'followed' bucket")
+		}
+
+		rel := &relationship{}
+		if bs := bk.Get([]byte(id)); bs != nil {
+			if err := json.Unmarshal(bs, rel); err != nil {
+				return errors.Wrapf(err, "invalid relationship for '%s'", id)
+			}
+		}
+		rel.State = relationUnfollowed
+		rel.UnfollowedAt = time.Now()
+		return putRelationship(bk, id, rel)
+	})
+}
+
+// getRelationships returns all records of the followed bucket by username
+func getRelationships(db *bolt.DB) (map[string]relationship, error) {
+	rels := make(map[string]relationship)
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("followed"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'followed' bucket")
+		}
+
+		return bk.ForEach(func(k, v []byte) error {
+			var rel relationship
+			if err := json.Unmarshal(v, &rel); err != nil {
+				logger.WithField("username", string(k)).WithError(err).Warn("invalid relationship")
+				return nil
+			}
+			rels[string(k)] = rel
+			return nil
+		})
+	})
+	return rels, err
 }
 
-func setFollowed(db *bolt.DB, id string) error {
-	d := time.Now().Format("20060102")
-	db.Update(func(tx *bolt.Tx) error {
+// syncRelationships updates the followed bucket with our current following and followers:
+// accepted requests become following, follow backs are detected and users we stopped following
+// outside of the bot are marked unfollowed.
+func syncRelationships(db *bolt.DB, following, followers []goinsta.User) error {
+	isFollowing := make(map[string]bool, len(following))
+	for _, user := range following {
+		isFollowing[user.Username] = true
+	}
+	isFollower := make(map[string]bool, len(followers))
+	for _, user := range followers {
+		isFollower[user.Username] = true
+	}
+
+	now := time.Now()
+	return db.Update(func(tx *bolt.Tx) error {
 		bk := tx.Bucket([]byte("followed"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'followed' bucket")
+		}
+
+		updated := make(map[string]*relationship)
+		err := bk.ForEach(func(k, v []byte) error {
+			var rel relationship
+			if err := json.Unmarshal(v, &rel); err != nil {
+				logger.WithField("username", string(k)).WithError(err).Warn("invalid relationship")
+				return nil
+			}
+
+			id := string(k)
+			changed := false
+			if isFollower[id] && rel.FollowBackAt.IsZero() && rel.State != relationUnfollowed {
+				rel.FollowBackAt = now
+				changed = true
+			}
 
-		err := bk.Put([]byte(id), []byte(d))
+			switch {
+			case rel.State == relationUnfollowed:
+			case isFollowing[id]:
+				if rel.State == relationRequested {
+					rel.State = relationFollowing
+					changed = true
+				}
+			case rel.State == relationFollowing:
+				rel.State = relationUnfollowed
+				rel.UnfollowedAt = now
+				changed = true
+			}
+			if changed {
+				updated[id] = &rel
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
+
+		for id, rel := range updated {
+			if err := putRelationship(bk, id, rel); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	return nil
+}
+
+// migrateFollowed converts the old "20060102" dates of the followed bucket to relationship records.
+// The old date was overwritten on unfollow, so the state is restored on the next /unfollow.
+func migrateFollowed(tx *bolt.Tx) (int, error) {
+	bk := tx.Bucket([]byte("followed"))
+	if bk == nil {
+		return 0, nil
+	}
+
+	legacy := make(map[string]*relationship)
+	err := bk.ForEach(func(k, v []byte) error {
+		if v == nil || bytes.HasPrefix(v, []byte("{")) {
+			return nil
+		}
+
+		rel := &relationship{Source: "legacy", State: relationFollowing}
+		if t, err := time.ParseInLocation("20060102", string(v), time.Local); err == nil {
+			rel.FollowedAt = t
+		} else {
+			logger.WithFields(logrus.Fields{"username": string(k), "date": string(v)}).Warn("invalid follow date")
+		}
+		legacy[string(k)] = rel
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for id, rel := range legacy {
+		if err := putRelationship(bk, id, rel); err != nil {
+			return 0, err
+		}
+	}
+	return len(legacy), nil
 }
 
 // updateDB : store data
@@ -254,7 +565,7 @@ func getUsersFromQueue(db *bolt.DB, limit int) []string {
 	})
 
 	if err != nil {
-		fmt.Printf("failure : %s\n", err)
+		logger.WithError(err).Error("can't read follow queue")
 	}
 	return usersQueue
 }
@@ -271,13 +582,13 @@ func iterateDB(db *bolt.DB, bucketName []byte) {
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			fmt.Printf("key=>[%s], value=[%s]\n", k, v)
+			logger.WithFields(logrus.Fields{"bucket": string(bucketName), "key": string(k), "value": string(v)}).Debug("record")
 		}
 		return nil
 	})
 
 	if err != nil {
-		fmt.Printf("failure : %s\n", err)
+		logger.WithField("bucket", string(bucketName)).WithError(err).Error("can't read bucket")
 	}
 }
 
@@ -287,7 +598,7 @@ func bucketStats(db *bolt.DB, bucketName string) bolt.BucketStats {
 		bucket = tx.Bucket([]byte(bucketName)).Stats()
 		return nil
 	}); err != nil {
-		fmt.Println(err)
+		logger.WithField("bucket", bucketName).WithError(err).Error("can't get bucket stats")
 	}
 	return bucket
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// actionBudget is an entry of the "budget" section of config, zero caps are unlimited
+type actionBudget struct {
+	PerHour int
+	PerDay  int
+	// Spacing is the minimal pause between two actions, a random pause up to Jitter is added to it
+	Spacing time.Duration
+	Jitter  time.Duration
+}
+
+// budgetActions are paced by takeBudget, browse (looking at an image of a tag) and tag (going to the next tag) only have spacing by default
+var budgetActions = []string{"follow", "unfollow", "like", "comment", "browse", "tag"}
+
+var budgetDefaults = map[string]actionBudget{
+	"follow":   {PerHour: 40, PerDay: 300, Spacing: 16 * time.Second, Jitter: 10 * time.Second},
+	"unfollow": {PerHour: 60, PerDay: 1000, Spacing: 30 * time.Second, Jitter: 10 * time.Second},
+	"like":     {PerHour: 60, PerDay: 500, Spacing: 15 * time.Second, Jitter: 5 * time.Second},
+	"comment":  {PerHour: 10, PerDay: 50, Spacing: 60 * time.Second, Jitter: 30 * time.Second},
+	"browse":   {Spacing: 17 * time.Second, Jitter: 5 * time.Second},
+	"tag":      {Spacing: 10 * time.Second, Jitter: 5 * time.Second},
+}
+
+// devSpacing replaces the budget in development mode, nothing is done for real so nothing is counted
+const devSpacing = 2 * time.Second
+
+// budgetMu makes checking and counting an action atomic for tasks running at the same time
+var budgetMu sync.Mutex
+
+// budgetUsage is the persisted usage of an action
+type budgetUsage struct {
+	Hour      time.Time `json:"hour"`
+	HourCount int       `json:"hour_count"`
+	Day       time.Time `json:"day"`
+	DayCount  int       `json:"day_count"`
+	// Next is the earliest time of the next action
+	Next time.Time `json:"next"`
+}
+
+// at returns the usage in the hour and day of now, counters of past periods are reset
+func (u budgetUsage) at(now time.Time) budgetUsage {
+	hour := now.Truncate(time.Hour)
+	if !u.Hour.Equal(hour) {
+		u.Hour = hour
+		u.HourCount = 0
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !u.Day.Equal(day) {
+		u.Day = day
+		u.DayCount = 0
+	}
+	return u
+}
+
+// budgetError is returned when the daily budget of an action is used up
+type budgetError struct {
+	action string
+	until  time.Time
+}
+
+func (e *budgetError) Error() string {
+	return fmt.Sprintf("daily %s budget is used up until %s", e.action, e.until.Format("2006-01-02 15:04"))
+}
+
+func setBudgetDefaults(v *viper.Viper) {
+	for action, defaults := range budgetDefaults {
+		v.SetDefault("budget."+action+".per_hour", defaults.PerHour)
+		v.SetDefault("budget."+action+".per_day", defaults.PerDay)
+		v.SetDefault("budget."+action+".spacing", defaults.Spacing.String())
+		v.SetDefault("budget."+action+".jitter", defaults.Jitter.String())
+	}
+}
+
+// getActionBudget returns the configured budget of action, it is read every time to follow config changes
+func getActionBudget(action string) actionBudget {
+	return actionBudget{
+		PerHour: viper.GetInt("budget." + action + ".per_hour"),
+		PerDay:  viper.GetInt("budget." + action + ".per_day"),
+		Spacing: viper.GetDuration("budget." + action + ".spacing"),
+		Jitter:  viper.GetDuration("budget." + action + ".jitter"),
+	}
+}
+
+// takeBudget waits until action is allowed by the budget shared by all tasks and counts it,
+// refundBudget gives it back if the action fails.
+// It waits for the spacing and for the next hour if the hourly cap is reached,
+// a *budgetError is returned if the daily cap is reached.
+func takeBudget(ctx context.Context, db *bolt.DB, action string) error {
+	if *dev {
+		return sleep(ctx, devSpacing)
+	}
+
+	for {
+		limits := getActionBudget(action)
+
+		budgetMu.Lock()
+		now := time.Now()
+		usage, err := getBudgetUsage(db, action)
+		if err != nil {
+			budgetMu.Unlock()
+			return err
+		}
+		usage = usage.at(now)
+
+		if limits.PerDay > 0 && usage.DayCount >= limits.PerDay {
+			budgetMu.Unlock()
+			return &budgetError{action: action, until: usage.Day.AddDate(0, 0, 1)}
+		}
+
+		var wait time.Duration
+		switch {
+		case limits.PerHour > 0 && usage.HourCount >= limits.PerHour:
+			wait = usage.Hour.Add(time.Hour).Sub(now)
+			logger.WithFields(logrus.Fields{"action": action, "wait": wait.Round(time.Second)}).Info("hourly budget is used up, waiting")
+		case now.Before(usage.Next):
+			wait = usage.Next.Sub(now)
+		default:
+			usage.HourCount++
+			usage.DayCount++
+			usage.Next = now.Add(limits.Spacing + jitter(limits.Jitter))
+			err := putBudgetUsage(db, action, usage)
+			budgetMu.Unlock()
+			return err
+		}
+		budgetMu.Unlock()
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// refundBudget uncounts an action taken by takeBudget which failed, the spacing after it is kept
+func refundBudget(db *bolt.DB, action string) {
+	if *dev {
+		return
+	}
+
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	now := time.Now()
+	usage, err := getBudgetUsage(db, action)
+	if err != nil {
+		logger.WithField("action", action).WithError(err).Error("can't get budget usage")
+		return
+	}
+	usage = usage.at(now)
+	if usage.HourCount > 0 {
+		usage.HourCount--
+	}
+	if usage.DayCount > 0 {
+		usage.DayCount--
+	}
+	if err := putBudgetUsage(db, action, usage); err != nil {
+		logger.WithField("action", action).WithError(err).Error("can't refund budget")
+	}
+}
+
+// budgetRemaining returns how many times action can be done today, math.MaxInt32 if there is no daily cap
+func budgetRemaining(db *bolt.DB, action string) int {
+	limits := getActionBudget(action)
+	if limits.PerDay <= 0 {
+		return math.MaxInt32
+	}
+
+	usage, err := getBudgetUsage(db, action)
+	if err != nil {
+		logger.WithField("action", action).WithError(err).Error("can't get budget usage")
+		return 0
+	}
+	usage = usage.at(time.Now())
+
+	if usage.DayCount >= limits.PerDay {
+		return 0
+	}
+	return limits.PerDay - usage.DayCount
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+func getBudgetUsage(db *bolt.DB, action string) (budgetUsage, error) {
+	var usage budgetUsage
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("budget"))
+		if bk == nil {
+			return nil
+		}
+
+		value := bk.Get([]byte(action))
+		if value == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(value, &usage); err != nil {
+			return errors.Wrapf(err, "invalid budget usage for '%s'", action)
+		}
+		return nil
+	})
+	return usage, err
+}
+
+func putBudgetUsage(db *bolt.DB, action string, usage budgetUsage) error {
+	value, err := json.Marshal(usage)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode budget usage for '%s'", action)
+	}
+	return updateDB(db, []byte("budget"), []byte(action), value)
+}
+
+// formatBudget shows the usage and the remaining budget of every action
+func formatBudget(db *bolt.DB) string {
+	now := time.Now()
+	lines := make([]string, 0, len(budgetActions))
+	for _, action := range budgetActions {
+		limits := getActionBudget(action)
+		usage, err := getBudgetUsage(db, action)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s — %s", action, err))
+			continue
+		}
+		usage = usage.at(now)
+
+		line := action + " —"
+		if limits.PerHour > 0 {
+			line += fmt.Sprintf(" %d/%d this hour,", usage.HourCount, limits.PerHour)
+		}
+		if limits.PerDay > 0 {
+			line += fmt.Sprintf(" %d/%d today (%d left),", usage.DayCount, limits.PerDay, budgetRemaining(db, action))
+		}
+		line += fmt.Sprintf(" every %s", limits.Spacing)
+		if limits.Jitter > 0 {
+			line += fmt.Sprintf(" + up to %s", limits.Jitter)
+		}
+		if wait := usage.Next.Sub(now).Round(time.Second); wait > 0 {
+			line += fmt.Sprintf(", next in %s", wait)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendBudget sends the remaining quota of every action
+func sendBudget(bot Messenger, db *bolt.DB, userID int64) {
+	msg := tgbotapi.NewMessage(userID, formatBudget(db))
+	msg.DisableNotification = true
+	bot.Send(msg)
+}
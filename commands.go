@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ad/cron"
+	"github.com/boltdb/bolt"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// dispatcher routes admin commands to the tasks and delivers task reports to the notifiers
+type dispatcher struct {
+	bot  Messenger
+	db   *bolt.DB
+	cron *cron.Cron
+
+	runner *taskRunner
+}
+
+// run handles incoming updates and task reports until updates is closed
+func (d *dispatcher) run(updates <-chan tgbotapi.Update) {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			d.handleUpdate(update)
+		case resp := <-telegramResp:
+			d.handleResponse(resp)
+		}
+	}
+}
+
+// handleUpdate executes the command from update if it was sent by an admin
+func (d *dispatcher) handleUpdate(update tgbotapi.Update) {
+	if update.Message == nil || update.Message.From == nil {
+		return
+	}
+
+	if !intInStringSlice(update.Message.From.ID, admins) {
+		return
+	}
+
+	bot := d.bot
+	db := d.db
+	userID := int64(update.Message.From.ID)
+
+	text := update.Message.Text
+	command := update.Message.Command()
+	args := update.Message.CommandArguments()
+
+	msg := tgbotapi.NewMessage(userID, "")
+	msg.DisableWebPagePreview = true
+	msg.DisableNotification = true
+
+	switch command {
+	case "login":
+		updateLogin(bot, args, userID)
+	case "relogin":
+		startLogin(bot, userID, func() string {
+			if err := createAndSaveSession(); err != nil {
+				return fmt.Sprintf("relogin failed with error %s", err)
+			}
+			return fmt.Sprintf("relogin done")
+		})
+	case "refollow":
+		if args == "" {
+			msg.Text = fmt.Sprintf("/refollow username")
+			bot.Send(msg)
+		} else {
+			startTask(bot, d.runner, "refollow", args, userID)
+		}
+	case "followlikers":
+		if args == "" {
+			msg.Text = fmt.Sprintf("/followlikers post link")
+			bot.Send(msg)
+		} else {
+			startTask(bot, d.runner, "followLikers", args, userID)
+		}
+	case "follow":
+		startTask(bot, d.runner, "follow", "", userID)
+	case "unfollow":
+		startTask(bot, d.runner, "unfollow", "", userID)
+	case "progress":
+		l.RLock()
+		var unfollowProgress = "not started"
+		if state["unfollow"] >= 0 {
+			unfollowProgress = fmt.Sprintf("%d%% [%d/%d]", state["unfollow"], state["unfollow_current"], state["unfollow_all_count"])
+		}
+		var followProgress = "not started"
+		if state["follow"] >= 0 {
+			followProgress = fmt.Sprintf("%d%% [%d/%d]", state["follow"], state["follow_current"], state["follow_all_count"])
+		}
+		var refollowProgress = "not started"
+		if state["refollow"] >= 0 {
+			refollowProgress = fmt.Sprintf("%d%% [%d/%d]", state["refollow"], state["refollow_current"], state["refollow_all_count"])
+		}
+		var followLikersProgress = "not started"
+		if state["followLikers"] >= 0 {
+			followLikersProgress = fmt.Sprintf("%d%% [%d/%d]", state["followLikers"], state["followLikers_current"], state["followLikers_all_count"])
+		}
+		l.RUnlock()
+		msg.Text = fmt.Sprintf("Unfollow — %s\nFollow — %s\nRefollow — %s\nfollowLikers - %s", unfollowProgress, followProgress, refollowProgress, followLikersProgress)
+		msgRes, err := bot.Send(msg)
+		if err == nil {
+			l.Lock()
+			editMessage["progress"][update.Message.From.ID] = msgRes.MessageID
+			l.Unlock()
+		}
+	case "cancelfollow":
+		cancelTask(bot, d.runner, "follow", userID)
+	case "cancelunfollow":
+		cancelTask(bot, d.runner, "unfollow", userID)
+	case "cancelrefollow":
+		cancelTask(bot, d.runner, "refollow", userID)
+	case "cancelfollowlikers":
+		cancelTask(bot, d.runner, "followLikers", userID)
+	case "cancelfollowqueue":
+		cancelTask(bot, d.runner, "followQueue", userID)
+	case "resume":
+		resumeSession(bot, db, d.runner, args, userID)
+	case "cooldown":
+		updateCooldown(bot, args, userID)
+	case "budget":
+		sendBudget(bot, db, userID)
+	case "tasks":
+		msg.Text = formatTasks(d.runner.List())
+		bot.Send(msg)
+	case "schedule":
+		updateSchedule(bot, args, userID)
+	case "loglevel":
+		updateLogLevel(bot, args, userID)
+	case "backup":
+		sendBackup(bot, db, userID)
+	case "audit":
+		sendAudit(bot, db, args, userID)
+	case "conversion":
+		sendConversion(bot, db, args, userID)
+	case "stats":
+		sendStats(bot, db, d.cron, args, userID)
+	case "getcomments":
+		sendList(bot, db, "comments", args, userID)
+	case "addcomments":
+		updateList(bot, db, "comments", args, false, userID)
+	case "removecomments":
+		updateList(bot, db, "comments", args, true, userID)
+	case "gettags":
+		sendList(bot, db, "tags", args, userID)
+	case "addtags":
+		updateList(bot, db, "tags", args, false, userID)
+	case "removetags":
+		updateList(bot, db, "tags", args, true, userID)
+	case "settag":
+		updateTagSettings(bot, db, args, userID)
+	case "getwhitelist":
+		sendList(bot, db, "whitelist", args, userID)
+	case "addwhitelist":
+		updateList(bot, db, "whitelist", args, false, userID)
+	case "removewhitelist":
+		updateList(bot, db, "whitelist", args, true, userID)
+	case "getlimits":
+		getLimits(bot, userID)
+	case "updatelimits":
+		updateLimits(bot, args, userID)
+	case "updateproxy":
+		updateProxy(bot, args, userID)
+	case "like":
+		startTask(bot, d.runner, "like", "", userID)
+	case "watch":
+		if args == "" {
+			msg.Text = fmt.Sprintf("/watch add | del | list")
+			bot.Send(msg)
+		} else {
+			watch(bot, db, args, userID)
+		}
+	case "startfollowqueue":
+		if args == "" {
+			args = "100"
+		}
+		startTask(bot, d.runner, "followQueue", args, userID)
+	case "queuesize":
+		sendQueueSize(bot, db, userID, "followqueue")
+	case "scrap":
+		startTask(bot, d.runner, "scrap", "", userID)
+
+	default:
+		msg.Text = text
+		msg.ReplyMarkup = commandKeyboard
+		bot.Send(msg)
+	}
+}
+
+// handleResponse sends a task report to the notifiers
+func (d *dispatcher) handleResponse(resp telegramResponse) {
+	notify(severityProgress, resp.key, resp.body)
+}
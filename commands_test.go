@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ahmdrz/goinsta/v2"
+)
+
+func newTestDispatcher(t *testing.T) (*dispatcher, *fakeMessenger, func()) {
+	db, _, cleanup := newTestEnv(t)
+	admins = []string{"1"}
+
+	bot := newFakeMessenger()
+	d := &dispatcher{bot: bot, db: db, runner: newTaskRunner()}
+	return d, bot, cleanup
+}
+
+func TestDispatcherWatchWithoutArgs(t *testing.T) {
+	d, bot, cleanup := newTestDispatcher(t)
+	defer cleanup()
+
+	d.handleUpdate(fakeUpdate(1, "/watch"))
+	d.handleUpdate(fakeUpdate(1, "/watch add"))
+	d.handleUpdate(fakeUpdate(1, "/watch del"))
+
+	texts := bot.Texts()
+	if len(texts) != 3 {
+		t.Fatalf("sent %d messages, want 3: %v", len(texts), texts)
+	}
+	for _, text := range texts {
+		if !strings.HasPrefix(text, "/watch add") {
+			t.Errorf("sent %q, want the usage", text)
+		}
+	}
+}
+
+func TestDispatcherTasks(t *testing.T) {
+	d, bot, cleanup := newTestDispatcher(t)
+	defer cleanup()
+
+	d.handleUpdate(fakeUpdate(1, "/tasks"))
+	d.runner.Register("follow", nil, func(ctx context.Context, _ string) error { return nil })
+	d.handleUpdate(fakeUpdate(1, "/tasks"))
+
+	texts := bot.Texts()
+	if len(texts) != 2 {
+		t.Fatalf("sent %d messages, want 2: %v", len(texts), texts)
+	}
+	if texts[0] != "No tasks" {
+		t.Errorf("sent %q without tasks, want %q", texts[0], "No tasks")
+	}
+	if !strings.HasPrefix(texts[1], "follow — ") {
+		t.Errorf("sent %q, want the follow task", texts[1])
+	}
+}
+
+func TestDispatcherUnknownCommand(t *testing.T) {
+	d, bot, cleanup := newTestDispatcher(t)
+	defer cleanup()
+
+	d.handleUpdate(fakeUpdate(1, "/nosuchcommand"))
+
+	if len(bot.Sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(bot.Sent))
+	}
+	if bot.Sent[0].Text != "/nosuchcommand" || bot.Sent[0].ReplyMarkup == nil {
+		t.Errorf("sent %q, want the command echoed with the keyboard", bot.Sent[0].Text)
+	}
+}
+
+func TestDispatcherIgnoresStrangers(t *testing.T) {
+	d, bot, cleanup := newTestDispatcher(t)
+	defer cleanup()
+
+	d.handleUpdate(fakeUpdate(2, "/tasks"))
+
+	if len(bot.Sent) != 0 {
+		t.Errorf("answered a user who is not an admin: %v", bot.Texts())
+	}
+}
+
+func TestDispatcherScrapRunsAsTask(t *testing.T) {
+	d, bot, cleanup := newTestDispatcher(t)
+	defer cleanup()
+	registerTasks(d.runner, d.db)
+
+	fake := instaClient.(*fakeInstagram)
+	fake.AddUser(goinsta.User{Username: "alice"})
+	fake.AddUser(goinsta.User{Username: "bob"})
+	fake.SetFollows("bob", "alice")
+	if err := setWatching(d.db, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	d.handleUpdate(fakeUpdate(1, "/scrap"))
+	d.runner.Wait("scrap")
+
+	if texts := bot.Texts(); len(texts) != 1 || texts[0] != "Starting scrap" {
+		t.Errorf("sent %v, want the task start", texts)
+	}
+	if status, _ := d.runner.Status("scrap"); status.State != taskIdle {
+		t.Errorf("scrap is %s: %v", status.State, status.LastError)
+	}
+	if queue := getUsersFromQueue(d.db, 10); len(queue) != 1 || queue[0] != "bob" {
+		t.Errorf("follow queue is %v, want [bob]", queue)
+	}
+}
+
+func TestDispatcherAuditSendsEveryEntry(t *testing.T) {
+	d, bot, cleanup := newTestDispatcher(t)
+	defer cleanup()
+
+	for i := 0; i < auditMaxLimit; i++ {
+		audit(d.db, "follow", fmt.Sprintf("user%03d", i), "refollow", "@"+strings.Repeat("x", 30), nil)
+	}
+	d.handleUpdate(fakeUpdate(1, fmt.Sprintf("/audit last %d", auditMaxLimit)))
+
+	texts := bot.Texts()
+	if len(texts) < 2 {
+		t.Fatalf("sent %d messages, want the entries split", len(texts))
+	}
+	entries := 0
+	for _, text := range texts {
+		if len(text) > telegramMessageLimit {
+			t.Errorf("sent %d bytes", len(text))
+		}
+		entries += strings.Count(text, "user")
+	}
+	if entries != auditMaxLimit {
+		t.Errorf("sent %d entries, want %d", entries, auditMaxLimit)
+	}
+}
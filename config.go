@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// limitRange is a like or comment entry of the "limits" section
+type limitRange struct {
+	Min   int
+	Max   int
+	Count int
+}
+
+// config is the validated config file, applyConfig copies it to the globals used by tasks
+type config struct {
+	Like                        limitRange
+	Comment                     limitRange
+	FollowCount                 int
+	PotencyRatio                float64
+	MaxLikesToAccountPerSession int
+
+	DatabasePath string
+
+	ReportID int64
+	Admins   []string
+
+	TelegramToken         string
+	TelegramProxy         string
+	TelegramProxyPort     int32
+	TelegramProxyUser     string
+	TelegramProxyPassword string
+
+	InstaUsername string
+	InstaPassword string
+	InstaProxy    string
+
+	Filters           []filterRule
+	FiltersConfigured bool
+}
+
+// configErrors lists every problem found in the config file
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+func (e *configErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// int reads a number which should not be less than min
+func (e *configErrors) int(v *viper.Viper, key string, min int) int {
+	value, err := cast.ToIntE(v.Get(key))
+	if err != nil {
+		e.add("%s should be a number", key)
+		return 0
+	}
+	if value < min {
+		e.add("%s should be at least %d, got %d", key, min, value)
+	}
+	return value
+}
+
+// duration reads a duration like "1h30m" which should not be negative
+func (e *configErrors) duration(v *viper.Viper, key string) {
+	value, err := time.ParseDuration(v.GetString(key))
+	if err != nil {
+		e.add("%s should be a duration like 30s or 1h", key)
+	} else if value < 0 {
+		e.add("%s should not be negative", key)
+	}
+}
+
+// limitRange reads min, max and count of a "limits" entry
+func (e *configErrors) limitRange(v *viper.Viper, key string) limitRange {
+	r := limitRange{
+		Min:   e.int(v, key+".min", 0),
+		Max:   e.int(v, key+".max", 0),
+		Count: e.int(v, key+".count", 0),
+	}
+	if r.Max < r.Min {
+		e.add("%s.max (%d) should not be less than %s.min (%d)", key, r.Max, key, r.Min)
+	}
+	return r
+}
+
+// validConfig is the content of the last accepted config file, it is restored when a changed file is rejected
+var validConfig []byte
+
+// configMu serializes the changes of viper by commands, the API and the reload of the changed config file
+var configMu sync.Mutex
+
+// setConfigDefaults sets the defaults of the keys read by readConfig and by tasks
+func setConfigDefaults(v *viper.Viper) {
+	v.SetDefault("limits.max_likes_to_account_per_session", 10)
+	v.SetDefault("database.path", "instabot.db")
+	v.SetDefault("backup.dir", "backups")
+	v.SetDefault("backup.keep", 7)
+	v.SetDefault("user.instagram.challenge_method", 1)
+	v.SetDefault("user.instagram.session.path", "instabot.session")
+	v.SetDefault("user.instagram.session.key_file", "instabot.key")
+
+	v.SetDefault("filters", defaultFilters)
+
+	setBudgetDefaults(v)
+	setCooldownDefaults(v)
+}
+
+// readConfig reads and validates the config loaded into v, the error lists every problem
+func readConfig(v *viper.Viper) (*config, error) {
+	var problems configErrors
+
+	c := &config{
+		Like:                        problems.limitRange(v, "limits.like"),
+		Comment:                     problems.limitRange(v, "limits.comment"),
+		FollowCount:                 problems.int(v, "limits.follow.count", 0),
+		MaxLikesToAccountPerSession: problems.int(v, "limits.max_likes_to_account_per_session", 0),
+
+		DatabasePath: v.GetString("database.path"),
+
+		Admins: v.GetStringSlice("user.telegram.admins"),
+
+		TelegramToken:         getSecret(v, "user.telegram.token"),
+		TelegramProxy:         v.GetString("user.telegram.proxy"),
+		TelegramProxyPort:     int32(problems.int(v, "user.telegram.proxy_port", 0)),
+		TelegramProxyUser:     getSecret(v, "user.telegram.proxy_user"),
+		TelegramProxyPassword: getSecret(v, "user.telegram.proxy_password"),
+
+		InstaUsername: v.GetString("user.instagram.username"),
+		InstaPassword: getSecret(v, "user.instagram.password"),
+		InstaProxy:    getSecret(v, "user.instagram.proxy"),
+	}
+
+	if ratio := v.Get("limits.follow.potency_ratio"); ratio != nil {
+		value, err := cast.ToFloat64E(ratio)
+		if err != nil {
+			problems.add("limits.follow.potency_ratio should be a number")
+		}
+		c.PotencyRatio = value
+	}
+
+	for _, limit := range []string{"max_unfollow_per_day", "days_before_unfollow", "max_retry"} {
+		problems.int(v, "limits."+limit, 0)
+	}
+
+	if c.InstaUsername == "" {
+		problems.add("user.instagram.username is required")
+	}
+	// the API can replace Telegram, but startAPI doesn't serve it without a token
+	if c.TelegramToken == "" && (v.GetString("api.listen") == "" || v.GetString("api.token") == "") {
+		problems.add("user.telegram.token is required unless the API is enabled with api.listen and api.token")
+	}
+	if len(c.Admins) == 0 {
+		problems.add("user.telegram.admins should list at least one Telegram user ID")
+	}
+	for _, admin := range c.Admins {
+		if _, err := strconv.ParseInt(admin, 10, 64); err != nil {
+			problems.add("user.telegram.admins should be numeric Telegram user IDs, got %q", admin)
+		}
+	}
+	reportID, err := cast.ToInt64E(v.Get("user.telegram.reportID"))
+	if err != nil {
+		problems.add("user.telegram.reportID should be a numeric Telegram chat ID")
+	}
+	c.ReportID = reportID
+	if method := problems.int(v, "user.instagram.challenge_method", 0); method > 1 {
+		problems.add("user.instagram.challenge_method should be 0 (SMS) or 1 (email)")
+	}
+
+	// tags, comments and whitelist only seed the database, so they can be empty
+	for _, tag := range v.GetStringSlice("tags") {
+		if strings.TrimSpace(tag) == "" || strings.HasPrefix(tag, "#") {
+			problems.add("tags should not be empty or start with #, got %q", tag)
+		}
+	}
+
+	if c.DatabasePath == "" {
+		problems.add("database.path is required")
+	}
+	problems.int(v, "backup.keep", 0)
+
+	for _, action := range budgetActions {
+		problems.int(v, "budget."+action+".per_hour", 0)
+		problems.int(v, "budget."+action+".per_day", 0)
+		problems.duration(v, "budget."+action+".spacing")
+		problems.duration(v, "budget."+action+".jitter")
+	}
+	for _, class := range blockingErrors {
+		problems.duration(v, "cooldown."+class)
+	}
+
+	filters, err := readFilterRules(v)
+	if rulesProblems, ok := err.(configErrors); ok {
+		problems = append(problems, rulesProblems...)
+	}
+	c.Filters = filters
+	c.FiltersConfigured = v.InConfig("filters")
+
+	if v.IsSet("log.level") {
+		if _, err := logrus.ParseLevel(v.GetString("log.level")); err != nil {
+			problems.add("log.level: %s", err)
+		}
+	}
+	if format := v.GetString("log.format"); format != "" && format != "text" && format != "json" {
+		problems.add("log.format should be text or json, got %q", format)
+	}
+	for _, name := range []string{"telegram", "stdout", "webhook", "smtp"} {
+		if v.IsSet("notify." + name + ".timeout") {
+			problems.duration(v, "notify."+name+".timeout")
+		}
+		for _, severityName := range v.GetStringSlice("notify." + name + ".severities") {
+			if _, err := parseSeverity(severityName); err != nil {
+				problems.add("notify.%s.severities: %s", name, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return c, nil
+}
+
+// applyConfig copies the validated config to the globals
+func applyConfig(c *config) {
+	likeLowerLimit = c.Like.Min
+	likeUpperLimit = c.Like.Max
+	likeCount = c.Like.Count
+
+	followCount = c.FollowCount
+	potencyRatio = c.PotencyRatio
+
+	commentLowerLimit = c.Comment.Min
+	commentUpperLimit = c.Comment.Max
+	commentCount = c.Comment.Count
+
+	maxLikesToAccountPerSession = c.MaxLikesToAccountPerSession
+
+	dbPath = c.DatabasePath
+
+	reportID = c.ReportID
+	admins = c.Admins
+
+	telegramToken = c.TelegramToken
+	telegramProxy = c.TelegramProxy
+	telegramProxyPort = c.TelegramProxyPort
+	telegramProxyUser = c.TelegramProxyUser
+	telegramProxyPassword = c.TelegramProxyPassword
+
+	instaUsername = c.InstaUsername
+	instaPassword = c.InstaPassword
+	instaProxy = c.InstaProxy
+
+	filterRules = c.Filters
+	filtersConfigured = c.FiltersConfigured
+}
+
+// setConfig changes a key of config, see changeConfig
+func setConfig(key string, value interface{}) error {
+	return changeConfig(map[string]interface{}{key: value})
+}
+
+// changeConfig writes values by their viper keys to the last accepted config file and applies it.
+// Only these keys are changed in the file, defaults and secrets from the environment are not written.
+// Nothing is written if the config becomes invalid.
+func changeConfig(values map[string]interface{}) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	decoder := json.NewDecoder(bytes.NewReader(validConfig))
+	decoder.UseNumber()
+	file := make(map[string]interface{})
+	if err := decoder.Decode(&file); err != nil {
+		return errors.Wrapf(err, "failed to parse config file '%s'", viper.ConfigFileUsed())
+	}
+	for key, value := range values {
+		setNestedKey(file, strings.Split(key, "."), value)
+	}
+	data, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	c, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(viper.ConfigFileUsed(), data, 0644); err != nil {
+		return err
+	}
+	return useConfig(data, c)
+}
+
+// setNestedKey sets the value at path, keys are matched ignoring case like viper does and missing objects are created
+func setNestedKey(object map[string]interface{}, path []string, value interface{}) {
+	key := path[0]
+	for existing := range object {
+		if strings.EqualFold(existing, key) {
+			key = existing
+			break
+		}
+	}
+	if len(path) == 1 {
+		object[key] = value
+		return
+	}
+
+	child, ok := object[key].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		object[key] = child
+	}
+	setNestedKey(child, path[1:], value)
+}
+
+// reloadConfig applies the changed config file. An invalid file is rejected and the previous config is kept.
+func reloadConfig() error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	data, err := ioutil.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	c, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	return useConfig(data, c)
+}
+
+// parseConfig validates the content of a config file on its own viper with the defaults,
+// so the global viper read by tasks never holds a rejected config
+func parseConfig(data []byte) (*config, error) {
+	v := viper.New()
+	v.SetConfigType(strings.TrimPrefix(filepath.Ext(viper.ConfigFileUsed()), "."))
+	setConfigDefaults(v)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return readConfig(v)
+}
+
+// useConfig loads data accepted by parseConfig into the global viper and applies c
+func useConfig(data []byte, c *config) error {
+	if err := viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	validConfig = data
+	applyConfig(c)
+	return nil
+}
+
+// watchConfig calls onChange when the config file is written or replaced.
+// viper.WatchConfig is not used, it reads the changed file into the global viper before it is validated.
+func watchConfig(onChange func(e fsnotify.Event)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Clean(viper.ConfigFileUsed())
+	// the directory is watched, editors replace the file instead of writing it
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) == file && e.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					onChange(e)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.WithError(err).Error("config watcher failed")
+			}
+		}
+	}()
+	return nil
+}
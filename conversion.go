@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// conversionSources are shown first and in this order, other sources follow sorted by name
+var conversionSources = []string{"tag", "refollow", "followlikers", "queue"}
+
+// conversion counts follows and follow backs of a source
+type conversion struct {
+	Name       string
+	Followed   int
+	FollowBack int
+	days       []float64
+	details    map[string]*conversion
+}
+
+func (c *conversion) add(rel relationship) {
+	c.Followed++
+	if !rel.FollowBackAt.IsZero() {
+		c.FollowBack++
+		c.days = append(c.days, rel.FollowBackAt.Sub(rel.FollowedAt).Hours()/24)
+	}
+}
+
+// Rate is the share of followed users who followed back, in percents
+func (c *conversion) Rate() float64 {
+	if c.Followed == 0 {
+		return 0
+	}
+	return float64(c.FollowBack) * 100 / float64(c.Followed)
+}
+
+// MedianDays is the median number of days between our follow and the follow back
+func (c *conversion) MedianDays() float64 {
+	if len(c.days) == 0 {
+		return 0
+	}
+
+	days := append([]float64(nil), c.days...)
+	sort.Float64s(days)
+	middle := len(days) / 2
+	if len(days)%2 == 0 {
+		return (days[middle-1] + days[middle]) / 2
+	}
+	return days[middle]
+}
+
+func (c *conversion) String() string {
+	text := fmt.Sprintf("%s — %d followed, %d back (%.1f%%)", c.Name, c.Followed, c.FollowBack, c.Rate())
+	if c.FollowBack > 0 {
+		text += fmt.Sprintf(", median %.1f days", c.MedianDays())
+	}
+	return text
+}
+
+// getConversions groups the users followed from..to by source and source detail
+func getConversions(db *bolt.DB, from, to time.Time) ([]*conversion, error) {
+	rels, err := getRelationships(db)
+	if err != nil {
+		return nil, err
+	}
+
+	end := to.AddDate(0, 0, 1)
+	sources := make(map[string]*conversion)
+	for _, rel := range rels {
+		if rel.FollowedAt.IsZero() || rel.FollowedAt.Before(from) || !rel.FollowedAt.Before(end) {
+			continue
+		}
+
+		name := rel.Source
+		if name == "" {
+			name = "unknown"
+		}
+		source, ok := sources[name]
+		if !ok {
+			source = &conversion{Name: name, details: make(map[string]*conversion)}
+			sources[name] = source
+		}
+		source.add(rel)
+
+		if rel.SourceDetail == "" {
+			continue
+		}
+		detail, ok := source.details[rel.SourceDetail]
+		if !ok {
+			detail = &conversion{Name: conversionDetail(name, rel.SourceDetail)}
+			source.details[rel.SourceDetail] = detail
+		}
+		detail.add(rel)
+	}
+
+	var result []*conversion
+	for _, name := range conversionSources {
+		if source, ok := sources[name]; ok {
+			result = append(result, source)
+		}
+	}
+	var other []string
+	for name := range sources {
+		if !stringInStringSlice(name, conversionSources) {
+			other = append(other, name)
+		}
+	}
+	sort.Strings(other)
+	for _, name := range other {
+		result = append(result, sources[name])
+	}
+	return result, nil
+}
+
+func conversionDetail(source, detail string) string {
+	switch source {
+	case "tag":
+		return "#" + detail
+	case "refollow":
+		return "@" + detail
+	}
+	return detail
+}
+
+// sortedDetails returns the details of a source, best converting first
+func (c *conversion) sortedDetails() []*conversion {
+	details := make([]*conversion, 0, len(c.details))
+	for _, detail := range c.details {
+		details = append(details, detail)
+	}
+	sort.Slice(details, func(i, j int) bool {
+		if details[i].Rate() != details[j].Rate() {
+			return details[i].Rate() > details[j].Rate()
+		}
+		return details[i].Name < details[j].Name
+	})
+	return details
+}
+
+// sendConversion shows follow back rates by source for the users followed in the range from args
+func sendConversion(bot Messenger, db *bolt.DB, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+	msg.DisableWebPagePreview = true
+
+	from, to := time.Time{}, time.Now()
+	title := "all time"
+	if strings.TrimSpace(args) != "" {
+		var err error
+		from, to, err = parseStatsRange(args, time.Now())
+		if err != nil {
+			msg.Text = fmt.Sprintf("%s\n/conversion [7d | month | 2006-01-02..2006-01-31]", err)
+			bot.Send(msg)
+			return
+		}
+		title = fmt.Sprintf("%s..%s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+
+	sources, err := getConversions(db, from, to)
+	if err != nil {
+		msg.Text = err.Error()
+		bot.Send(msg)
+		return
+	}
+	if len(sources) == 0 {
+		msg.Text = "No follows found"
+		bot.Send(msg)
+		return
+	}
+
+	lines := []string{fmt.Sprintf("Follow back conversion, %s", title)}
+	for _, source := range sources {
+		lines = append(lines, "", source.String())
+		for _, detail := range source.sortedDetails() {
+			lines = append(lines, "  "+detail.String())
+		}
+	}
+
+	for _, text := range splitMessage(lines, telegramMessageLimit) {
+		msg.Text = text
+		bot.Send(msg)
+	}
+}
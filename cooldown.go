@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// classes of Instagram errors, the blocking ones pause every task for the configured cool-down
+const (
+	errorFeedbackRequired   = "feedback_required"
+	errorRateLimited        = "rate_limited"
+	errorLoginRequired      = "login_required"
+	errorCheckpointRequired = "checkpoint_required"
+	errorNotFound           = "not_found"
+	errorPrivate            = "private"
+)
+
+var blockingErrors = []string{errorFeedbackRequired, errorRateLimited, errorLoginRequired, errorCheckpointRequired}
+
+var cooldownDefaults = map[string]time.Duration{
+	errorFeedbackRequired:   12 * time.Hour,
+	errorRateLimited:        time.Hour,
+	errorLoginRequired:      30 * time.Minute,
+	errorCheckpointRequired: 24 * time.Hour,
+}
+
+// cooldownCheck is how often paused tasks check if the cool-down was cleared
+const cooldownCheck = time.Minute
+
+// pause is the persisted global cool-down
+type pause struct {
+	Until time.Time `json:"until"`
+	Class string    `json:"class"`
+	Error string    `json:"error"`
+}
+
+var (
+	cooldownMu sync.RWMutex
+	cooldown   pause
+	// cooldownDB keeps the cool-down over restarts, it is set by initCooldown
+	cooldownDB *bolt.DB
+)
+
+// classifyError returns the class of an Instagram error, or an empty string for other errors
+func classifyError(err error) string {
+	switch e := errors.Cause(err).(type) {
+	case goinsta.ErrorN:
+		return classifyResponse(e.ErrorType, e.Message)
+	case *goinsta.ErrorN:
+		return classifyResponse(e.ErrorType, e.Message)
+	case goinsta.Error400:
+		return classifyResponse(e.Action, e.Payload.Message)
+	case *goinsta.Error400:
+		return classifyResponse(e.Action, e.Payload.Message)
+	case goinsta.Error503, *goinsta.Error503:
+		// Instagram answers 503 when it throttles the account
+		return errorRateLimited
+	case error:
+		// goinsta returns responses which are not JSON as "Invalid status code: 429: <body>"
+		var code int
+		if _, scanErr := fmt.Sscanf(e.Error(), "Invalid status code: %d:", &code); scanErr == nil {
+			switch code {
+			case 429:
+				return errorRateLimited
+			case 404:
+				return errorNotFound
+			}
+		}
+	}
+	return ""
+}
+
+// classifyResponse returns the class of an error response of Instagram by its type and message
+func classifyResponse(errorType, message string) string {
+	message = strings.ToLower(message)
+	switch {
+	case errorType == "feedback_required", message == "feedback_required":
+		return errorFeedbackRequired
+	case errorType == "rate_limit_error", strings.HasPrefix(message, "please wait a few minutes"):
+		return errorRateLimited
+	case errorType == "login_required", message == "login_required":
+		return errorLoginRequired
+	case errorType == "checkpoint_challenge_required", errorType == "challenge_required", message == "checkpoint_required", message == "challenge_required":
+		return errorCheckpointRequired
+	case errorType == "user_not_found", message == "user not found", message == "media not found or unavailable":
+		return errorNotFound
+	case message == "not authorized to view user":
+		return errorPrivate
+	}
+	return ""
+}
+
+// isBlockingError reports whether err is an action block or a session problem
+func isBlockingError(err error) bool {
+	return stringInStringSlice(classifyError(err), blockingErrors)
+}
+
+func setCooldownDefaults(v *viper.Viper) {
+	for class, duration := range cooldownDefaults {
+		v.SetDefault("cooldown."+class, duration.String())
+	}
+}
+
+// initCooldown loads the cool-down saved before restart
+func initCooldown(db *bolt.DB) {
+	saved, err := getCooldown(db)
+	if err != nil {
+		logger.WithError(err).Error("can't load cool-down")
+	}
+
+	cooldownMu.Lock()
+	cooldownDB = db
+	cooldown = saved
+	cooldownMu.Unlock()
+
+	if time.Now().Before(saved.Until) {
+		notify(severityWarning, "", fmt.Sprintf("Tasks are paused after %s until %s", saved.Class, saved.Until.Format("2006-01-02 15:04")))
+	}
+}
+
+// handleInstagramError counts err and starts the cool-down if it is blocking
+func handleInstagramError(err error) {
+	if err == nil || err == context.Canceled || err == goinsta.ErrNoMore {
+		return
+	}
+
+	countInstagramError(err)
+
+	class := classifyError(err)
+	if !stringInStringSlice(class, blockingErrors) {
+		return
+	}
+
+	duration := viper.GetDuration("cooldown." + class)
+	if duration <= 0 {
+		return
+	}
+	until := time.Now().Add(duration)
+
+	cooldownMu.Lock()
+	if !until.After(cooldown.Until) {
+		cooldownMu.Unlock()
+		return
+	}
+	cooldown = pause{Until: until, Class: class, Error: err.Error()}
+	db := cooldownDB
+	current := cooldown
+	cooldownMu.Unlock()
+
+	logger.WithFields(logrus.Fields{"class": class, "until": until}).WithError(err).Warn("Instagram error, pausing all tasks")
+	if db != nil {
+		if err := putCooldown(db, current); err != nil {
+			logger.WithError(err).Error("can't save cool-down")
+		}
+	}
+	notify(severityWarning, "", fmt.Sprintf("Instagram error %s: %s\nAll tasks are paused until %s", class, err, until.Format("2006-01-02 15:04")))
+}
+
+// getCurrentCooldown returns the cool-down if it is not over yet
+func getCurrentCooldown() (pause, bool) {
+	cooldownMu.RLock()
+	defer cooldownMu.RUnlock()
+	return cooldown, time.Now().Before(cooldown.Until)
+}
+
+// waitCooldown blocks while tasks are paused, returns ctx.Err() if ctx was cancelled earlier
+func waitCooldown(ctx context.Context) error {
+	for {
+		current, active := getCurrentCooldown()
+		if !active {
+			return nil
+		}
+
+		wait := time.Until(current.Until)
+		if wait > cooldownCheck {
+			wait = cooldownCheck
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// clearCooldown resumes the tasks before the cool-down is over
+func clearCooldown() error {
+	cooldownMu.Lock()
+	cooldown = pause{}
+	db := cooldownDB
+	cooldownMu.Unlock()
+
+	if db == nil {
+		return nil
+	}
+	return putCooldown(db, pause{})
+}
+
+func getCooldown(db *bolt.DB) (pause, error) {
+	var saved pause
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("cooldown"))
+		if bk == nil {
+			return nil
+		}
+
+		value := bk.Get([]byte("pause"))
+		if value == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(value, &saved); err != nil {
+			return errors.Wrapf(err, "invalid cool-down")
+		}
+		return nil
+	})
+	return saved, err
+}
+
+func putCooldown(db *bolt.DB, saved pause) error {
+	value, err := json.Marshal(saved)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode cool-down")
+	}
+	return updateDB(db, []byte("cooldown"), []byte("pause"), value)
+}
+
+// updateCooldown shows the cool-down or clears it
+func updateCooldown(bot Messenger, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+
+	switch strings.TrimSpace(args) {
+	case "":
+		current, active := getCurrentCooldown()
+		if active {
+			msg.Text = fmt.Sprintf("Tasks are paused after %s until %s\n%s\n/cooldown clear", current.Class, current.Until.Format("2006-01-02 15:04"), current.Error)
+		} else {
+			msg.Text = "No cool-down"
+		}
+	case "clear":
+		if err := clearCooldown(); err != nil {
+			msg.Text = err.Error()
+		} else {
+			msg.Text = "Cool-down cleared, tasks are resumed"
+		}
+	default:
+		msg.Text = "/cooldown | /cooldown clear"
+	}
+	bot.Send(msg)
+}
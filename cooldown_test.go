@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ahmdrz/goinsta/v2"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	var badRequest goinsta.Error400
+	badRequest.Payload.Message = "feedback_required"
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{goinsta.ErrorN{Status: "fail", Message: "feedback_required", ErrorType: "feedback_required"}, errorFeedbackRequired},
+		{badRequest, errorFeedbackRequired},
+		{goinsta.ErrorN{Status: "fail", Message: "Please wait a few minutes before you try again."}, errorRateLimited},
+		{goinsta.Error503{Message: "Instagram API error. Try it later."}, errorRateLimited},
+		{fmt.Errorf("Invalid status code: 429: <html></html>"), errorRateLimited},
+		{goinsta.ErrorN{Status: "fail", Message: "login_required"}, errorLoginRequired},
+		{&goinsta.ErrorN{Status: "fail", Message: "checkpoint_required", ErrorType: "checkpoint_challenge_required"}, errorCheckpointRequired},
+		{goinsta.ErrorN{Status: "fail", Message: "User not found", ErrorType: "user_not_found"}, errorNotFound},
+		{fmt.Errorf("Invalid status code: 404: <html></html>"), errorNotFound},
+		{goinsta.ErrorN{Status: "fail", Message: "Not authorized to view user"}, errorPrivate},
+		{pkgerrors.Wrap(goinsta.ErrorN{Status: "fail", Message: "login_required"}, "can't sync"), errorLoginRequired},
+
+		// plain errors which only mention a class are not Instagram answers
+		{errors.New("private key not found"), ""},
+		{errors.New("feedback_required"), ""},
+		{goinsta.ErrorN{Status: "fail", Message: "Sorry, this photo has been deleted (private)"}, ""},
+		{fmt.Errorf("Invalid status code: 500: oops"), ""},
+	}
+
+	for _, test := range tests {
+		if got := classifyError(test.err); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+const (
+	// dashboardCookie keeps the API token in the browser
+	dashboardCookie = "instabot_token"
+	// dashboardHeader is added by the dashboard to requests which change something
+	dashboardHeader = "X-Instabot-Dashboard"
+)
+
+// handleDashboard serves the web UI, it only uses the API, so everything is in a single page
+func (s *apiServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'")
+	if err := dashboardTemplate.Execute(w, map[string]string{"Header": dashboardHeader}); err != nil {
+		logger.WithError(err).Error("can't render dashboard")
+	}
+}
+
+// handleLogin asks for the API token and keeps it in a cookie
+func (s *apiServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var failed bool
+	if r.Method == http.MethodPost {
+		if s.validToken(r.PostFormValue("token")) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     dashboardCookie,
+				Value:    s.token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		failed = true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if failed {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	if err := loginTemplate.Execute(w, failed); err != nil {
+		logger.WithError(err).Error("can't render login page")
+	}
+}
+
+const dashboardStyle = `
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; background: #f4f5f7; color: #222; }
+header { background: #3b5998; color: #fff; padding: 12px 24px; font-size: 20px; }
+main { display: grid; grid-template-columns: repeat(auto-fit, minmax(420px, 1fr)); gap: 16px; padding: 16px; }
+section { background: #fff; border-radius: 6px; padding: 12px 16px; box-shadow: 0 1px 2px rgba(0,0,0,.1); }
+h2 { font-size: 16px; margin: 0 0 8px; }
+table { width: 100%; border-collapse: collapse; font-size: 13px; }
+td, th { text-align: left; padding: 4px; border-bottom: 1px solid #eee; }
+.bar { background: #e4e6eb; border-radius: 3px; height: 8px; }
+.bar div { background: #3b5998; border-radius: 3px; height: 8px; }
+.error { color: #c0392b; }
+.item { display: inline-block; background: #e4e6eb; border-radius: 12px; padding: 2px 8px; margin: 2px; font-size: 13px; }
+.item button { border: 0; background: none; cursor: pointer; color: #888; }
+.scroll { max-height: 320px; overflow-y: auto; }
+svg text { font-size: 10px; fill: #555; }
+`
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-instabot</title>
+<style>` + dashboardStyle + `</style>
+</head>
+<body>
+<header>go-instabot</header>
+<main>
+<section>
+<h2>API token</h2>
+{{if .}}<p class="error">Invalid token</p>{{end}}
+<form method="post" action="/login">
+<input type="password" name="token" autofocus>
+<button type="submit">Login</button>
+</form>
+</section>
+</main>
+</body>
+</html>
+`))
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-instabot</title>
+<style>` + dashboardStyle + `</style>
+</head>
+<body>
+<header>go-instabot</header>
+<main>
+<section>
+<h2>Tasks</h2>
+<table id="tasks"></table>
+<p class="error" id="tasks-error"></p>
+</section>
+
+<section>
+<h2>Actions, last 14 days</h2>
+<div id="stats"></div>
+</section>
+
+<section>
+<h2>Follow queue <span id="queue-size"></span></h2>
+<div class="scroll" id="queue"></div>
+</section>
+
+<section>
+<h2>Tags</h2>
+<div id="tags"></div>
+<form data-list="tags"><input name="item" placeholder="tag"> <button type="submit">Add</button></form>
+</section>
+
+<section>
+<h2>Whitelist</h2>
+<div id="whitelist"></div>
+<form data-list="whitelist"><input name="item" placeholder="username"> <button type="submit">Add</button></form>
+</section>
+
+<section>
+<h2>Audit</h2>
+<div class="scroll"><table id="audit"></table></div>
+</section>
+</main>
+
+<script>
+"use strict";
+
+// tasks which need an argument to start
+var taskArgs = {refollow: "username", followLikers: "post link", followQueue: "batch size"};
+var colors = {follow: "#3b5998", like: "#e74c3c", comment: "#27ae60", unfollow: "#7f8c8d"};
+
+function api(method, path, body) {
+	var options = {method: method, credentials: "same-origin", headers: {"{{.Header}}": "1"}};
+	if (body !== undefined) {
+		options.headers["Content-Type"] = "application/json";
+		options.body = JSON.stringify(body);
+	}
+	return fetch(path, options).then(function (resp) {
+		if (resp.status === 401) {
+			location.href = "/login";
+		}
+		return resp.json().then(function (data) {
+			if (!resp.ok) {
+				throw new Error(data.error || resp.statusText);
+			}
+			return data;
+		});
+	});
+}
+
+function el(tag, text, attrs) {
+	var node = document.createElement(tag);
+	if (text !== undefined) {
+		node.textContent = text;
+	}
+	for (var name in attrs || {}) {
+		node.setAttribute(name, attrs[name]);
+	}
+	return node;
+}
+
+function showError(err) {
+	document.getElementById("tasks-error").textContent = err.message;
+}
+
+function loadTasks() {
+	Promise.all([api("GET", "/api/tasks"), api("GET", "/api/progress")]).then(function (res) {
+		var tasks = res[0], progress = res[1];
+		var table = document.getElementById("tasks");
+		table.textContent = "";
+		tasks.forEach(function (task) {
+			var row = table.insertRow();
+			row.insertCell().textContent = task.name;
+			row.insertCell().textContent = task.state + (task.last_error ? " (" + task.last_error + ")" : "");
+
+			var cell = row.insertCell();
+			var percent = progress[task.name];
+			if (task.state === "running" && percent >= 0) {
+				var bar = el("div", undefined, {"class": "bar", title: (progress[task.name + "_current"] || 0) + "/" + (progress[task.name + "_all_count"] || 0)});
+				var fill = el("div");
+				fill.style.width = Math.min(percent, 100) + "%";
+				bar.appendChild(fill);
+				cell.appendChild(bar);
+			}
+
+			var action = row.insertCell();
+			var running = task.state === "running" || task.state === "cancelling";
+			var button = el("button", running ? "Cancel" : "Start");
+			button.disabled = task.state === "cancelling";
+			button.onclick = function () {
+				if (running) {
+					api("POST", "/api/tasks/" + task.name + "/cancel").then(loadTasks, showError);
+					return;
+				}
+				var body = {};
+				if (taskArgs[task.name]) {
+					var arg = prompt(task.name + ": " + taskArgs[task.name]);
+					if (arg === null) {
+						return;
+					}
+					body.arg = arg;
+				}
+				api("POST", "/api/tasks/" + task.name + "/start", body).then(loadTasks, showError);
+			};
+			action.appendChild(button);
+		});
+	}, showError);
+}
+
+function loadStats() {
+	api("GET", "/api/stats?range=14d").then(function (days) {
+		var container = document.getElementById("stats");
+		container.textContent = "";
+
+		var actions = {};
+		days.forEach(function (day) {
+			for (var action in day.counts) {
+				actions[action] = true;
+			}
+		});
+		Object.keys(actions).sort().forEach(function (action) {
+			var counts = days.map(function (day) {
+				var total = 0;
+				for (var source in day.counts[action] || {}) {
+					total += day.counts[action][source];
+				}
+				return total;
+			});
+			var max = Math.max.apply(null, counts.concat([1]));
+			var width = 28, height = 60;
+
+			container.appendChild(el("div", action + " — " + counts.reduce(function (a, b) { return a + b; }, 0)));
+			var svg = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+			svg.setAttribute("width", days.length * width);
+			svg.setAttribute("height", height + 24);
+			counts.forEach(function (count, i) {
+				var h = Math.round(count * height / max);
+				var rect = document.createElementNS(svg.namespaceURI, "rect");
+				rect.setAttribute("x", i * width + 2);
+				rect.setAttribute("y", height - h + 12);
+				rect.setAttribute("width", width - 4);
+				rect.setAttribute("height", h);
+				rect.setAttribute("fill", colors[action] || "#8e44ad");
+				svg.appendChild(rect);
+
+				var value = document.createElementNS(svg.namespaceURI, "text");
+				value.setAttribute("x", i * width + 2);
+				value.setAttribute("y", height - h + 10);
+				value.textContent = count || "";
+				svg.appendChild(value);
+
+				var label = document.createElementNS(svg.namespaceURI, "text");
+				label.setAttribute("x", i * width + 2);
+				label.setAttribute("y", height + 22);
+				label.textContent = days[i].day.slice(8);
+				svg.appendChild(label);
+			});
+			container.appendChild(svg);
+		});
+		if (!container.firstChild) {
+			container.textContent = "No actions";
+		}
+	}, showError);
+}
+
+function loadQueue() {
+	api("GET", "/api/queue?limit=200").then(function (queue) {
+		document.getElementById("queue-size").textContent = "(" + queue.size + ")";
+		var container = document.getElementById("queue");
+		container.textContent = "";
+		queue.users.forEach(function (user) {
+			container.appendChild(el("span", user, {"class": "item"}));
+		});
+	}, showError);
+}
+
+function showList(name, items) {
+	var container = document.getElementById(name);
+	container.textContent = "";
+	items.forEach(function (item) {
+		var span = el("span", item + " ", {"class": "item"});
+		var remove = el("button", "×", {title: "Remove"});
+		remove.onclick = function () {
+			api("DELETE", "/api/" + name, {items: [item]}).then(function (items) { showList(name, items); }, showError);
+		};
+		span.appendChild(remove);
+		container.appendChild(span);
+	});
+}
+
+function loadList(name) {
+	api("GET", "/api/" + name).then(function (items) { showList(name, items); }, showError);
+}
+
+function loadAudit() {
+	api("GET", "/api/audit?limit=100").then(function (entries) {
+		var table = document.getElementById("audit");
+		table.textContent = "";
+		entries.forEach(function (entry) {
+			var row = table.insertRow();
+			row.insertCell().textContent = new Date(entry.time).toLocaleString();
+			row.insertCell().textContent = entry.action;
+			row.insertCell().textContent = entry.target;
+			row.insertCell().textContent = entry.source;
+			var result = row.insertCell();
+			result.textContent = entry.error ? "✗ " + entry.error : (entry.dev ? "dev" : "✓");
+			if (entry.error) {
+				result.className = "error";
+			}
+		});
+	}, showError);
+}
+
+document.querySelectorAll("form[data-list]").forEach(function (form) {
+	form.onsubmit = function (event) {
+		event.preventDefault();
+		var name = form.getAttribute("data-list");
+		var item = form.item.value.trim();
+		if (item === "") {
+			return;
+		}
+		api("POST", "/api/" + name, {items: [item]}).then(function (items) {
+			form.item.value = "";
+			showList(name, items);
+		}, showError);
+	};
+});
+
+loadTasks();
+loadStats();
+loadQueue();
+loadList("tags");
+loadList("whitelist");
+loadAudit();
+
+setInterval(loadTasks, 3000);
+setInterval(function () {
+	loadStats();
+	loadQueue();
+	loadAudit();
+}, 60000);
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/boltdb/bolt"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// actions of the filter rules, candidates matching no rule are followed
+const (
+	filterSkip     = "skip"
+	filterLikeOnly = "like-only"
+	filterFollow   = "follow"
+)
+
+var filterActions = []string{filterSkip, filterLikeOnly, filterFollow}
+
+// filter attributes by kind, post attributes are only known for candidates found by tags
+var (
+	filterNumbers = []string{"followers", "following", "ratio", "media_count", "post_age", "likes", "comments"}
+	filterFlags   = []string{"is_private", "is_verified", "is_business", "has_profile_pic", "whitelisted"}
+	filterTexts   = []string{"bio", "caption"}
+
+	filterPostAttributes = []string{"post_age", "likes", "comments", "caption"}
+	// filterShortAttributes are known from the short user records of followers and likers lists
+	filterShortAttributes = []string{"is_private", "is_verified", "has_profile_pic", "whitelisted"}
+)
+
+var filterNumberOps = []string{"<", "<=", ">", ">=", "=", "!="}
+
+// filterCondition compares an attribute of a candidate with a value
+type filterCondition struct {
+	attribute string
+	op        string
+	number    float64
+	flag      bool
+	keywords  []string
+}
+
+// filterRule is an entry of the "filters" section of config, all conditions must match
+type filterRule struct {
+	conditions []filterCondition
+	action     string
+	reason     string
+}
+
+// filterRules are the rules of the applied config, the first matching rule decides
+var filterRules []filterRule
+
+// filtersConfigured is set when config has a "filters" section. Without it the like and comment thresholds
+// and the potency ratio of the tags are checked too, with it the rules are the only check.
+var filtersConfigured bool
+
+// defaultFilters keeps the whitelist and private account skips of older versions when there is no "filters" section
+var defaultFilters = []map[string]interface{}{
+	{"attribute": "whitelisted", "value": true, "action": filterSkip, "reason": "in whitelist"},
+	{"attribute": "is_private", "value": true, "action": filterSkip, "reason": "private account"},
+}
+
+// candidate is a user who may be liked or followed, with the post which found them and the database of the lists
+type candidate struct {
+	db   *bolt.DB
+	user *goinsta.User
+	item *goinsta.Item
+}
+
+// number returns a numeric attribute, post_age is in seconds. ok is false for post attributes without a post.
+func (c candidate) number(attribute string) (value float64, ok bool) {
+	switch attribute {
+	case "followers":
+		return float64(c.user.FollowerCount), true
+	case "following":
+		return float64(c.user.FollowingCount), true
+	case "ratio":
+		if c.user.FollowerCount == 0 || c.user.FollowingCount == 0 {
+			return 0, true
+		}
+		return float64(c.user.FollowingCount) / float64(c.user.FollowerCount), true
+	case "media_count":
+		return float64(c.user.MediaCount), true
+	}
+
+	if c.item == nil {
+		return 0, false
+	}
+	switch attribute {
+	case "post_age":
+		return time.Since(time.Unix(c.item.TakenAt, 0)).Seconds(), true
+	case "likes":
+		return float64(c.item.Likes), true
+	case "comments":
+		return float64(c.item.CommentCount), true
+	}
+	return 0, false
+}
+
+func (c candidate) flag(attribute string) bool {
+	switch attribute {
+	case "is_private":
+		return c.user.IsPrivate
+	case "is_verified":
+		return c.user.IsVerified
+	case "is_business":
+		return c.user.IsBusiness
+	case "has_profile_pic":
+		return !c.user.HasAnonymousProfilePicture
+	case "whitelisted":
+		return inList(c.db, "whitelist", c.user.Username)
+	}
+	return false
+}
+
+func (c candidate) text(attribute string) (string, bool) {
+	switch attribute {
+	case "bio":
+		return c.user.Biography, true
+	case "caption":
+		if c.item == nil {
+			return "", false
+		}
+		return c.item.Caption.Text, true
+	}
+	return "", false
+}
+
+func (f filterCondition) match(c candidate) bool {
+	switch {
+	case stringInStringSlice(f.attribute, filterFlags):
+		return (c.flag(f.attribute) == f.flag) == (f.op == "=")
+	case stringInStringSlice(f.attribute, filterTexts):
+		text, ok := c.text(f.attribute)
+		if !ok {
+			return false
+		}
+		text = strings.ToLower(text)
+		found := false
+		for _, keyword := range f.keywords {
+			if strings.Contains(text, keyword) {
+				found = true
+				break
+			}
+		}
+		return found == (f.op == "contains")
+	}
+
+	value, ok := c.number(f.attribute)
+	if !ok {
+		return false
+	}
+	switch f.op {
+	case "<":
+		return value < f.number
+	case "<=":
+		return value <= f.number
+	case ">":
+		return value > f.number
+	case ">=":
+		return value >= f.number
+	case "=":
+		return value == f.number
+	case "!=":
+		return value != f.number
+	}
+	return false
+}
+
+func (r filterRule) match(c candidate) bool {
+	for _, condition := range r.conditions {
+		if !condition.match(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterCandidate returns the action of the first rule matching the user and the post which found them (nil outside of tags),
+// with the reason of the rule
+func filterCandidate(db *bolt.DB, user *goinsta.User, item *goinsta.Item) (action, reason string) {
+	c := candidate{db: db, user: user, item: item}
+	for _, rule := range filterRules {
+		if rule.match(c) {
+			return rule.action, rule.reason
+		}
+	}
+	return filterFollow, ""
+}
+
+// filtersNeedProfile reports whether the rules use attributes missing in the short user records of followers and likers lists
+func filtersNeedProfile() bool {
+	for _, rule := range filterRules {
+		for _, condition := range rule.conditions {
+			if !stringInStringSlice(condition.attribute, filterShortAttributes) && !stringInStringSlice(condition.attribute, filterPostAttributes) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterUser applies the rules to a user of a followers or likers list outside of tags.
+// The profile is fetched first, paced as browsing, if the user was restored from a session by username only
+// or if the rules need it, so it is fetched at most once. Users whose profile can't be fetched are skipped,
+// only budget and cancellation errors are returned.
+func filterUser(ctx context.Context, db *bolt.DB, ig InstagramClient, user *goinsta.User) (action, reason string, err error) {
+	if user.ID == 0 || filtersNeedProfile() {
+		if err := takeBudget(ctx, db, "browse"); err != nil {
+			return "", "", err
+		}
+		profile, err := ig.Profile(user.Username)
+		if err == context.Canceled {
+			return "", "", err
+		} else if err != nil {
+			return filterSkip, fmt.Sprintf("profile not found: %s", err), nil
+		}
+		*user = *profile
+	}
+
+	action, reason = filterCandidate(db, user, nil)
+	return action, reason, nil
+}
+
+// readFilterRules parses the "filters" section of v, the error lists every problem
+func readFilterRules(v *viper.Viper) ([]filterRule, error) {
+	var problems configErrors
+
+	entries, err := cast.ToSliceE(v.Get("filters"))
+	if err != nil {
+		problems.add("filters should be a list of rules")
+		return nil, problems
+	}
+
+	rules := make([]filterRule, 0, len(entries))
+	for i, entry := range entries {
+		key := fmt.Sprintf("filters[%d]", i)
+		fields, err := cast.ToStringMapE(entry)
+		if err != nil {
+			problems.add("%s should be an object", key)
+			continue
+		}
+
+		rule := filterRule{
+			action: cast.ToString(fields["action"]),
+			reason: cast.ToString(fields["reason"]),
+		}
+		if !stringInStringSlice(rule.action, filterActions) {
+			problems.add("%s.action should be one of %s, got %q", key, strings.Join(filterActions, ", "), rule.action)
+		}
+		if rule.reason == "" {
+			problems.add("%s.reason is required", key)
+		}
+
+		conditions := []map[string]interface{}{fields}
+		var and []interface{}
+		if fields["and"] != nil {
+			and, err = cast.ToSliceE(fields["and"])
+			if err != nil {
+				problems.add("%s.and should be a list of conditions", key)
+			}
+		}
+		for j, entry := range and {
+			condition, err := cast.ToStringMapE(entry)
+			if err != nil {
+				problems.add("%s.and[%d] should be an object", key, j)
+				continue
+			}
+			conditions = append(conditions, condition)
+		}
+
+		for j, fields := range conditions {
+			conditionKey := key
+			if j > 0 {
+				conditionKey = fmt.Sprintf("%s.and[%d]", key, j-1)
+			}
+			condition, err := parseFilterCondition(fields)
+			if err != nil {
+				problems.add("%s: %s", conditionKey, err)
+				continue
+			}
+			rule.conditions = append(rule.conditions, condition)
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return rules, nil
+}
+
+func parseFilterCondition(fields map[string]interface{}) (filterCondition, error) {
+	f := filterCondition{
+		attribute: cast.ToString(fields["attribute"]),
+		op:        cast.ToString(fields["op"]),
+	}
+	value := fields["value"]
+
+	switch {
+	case stringInStringSlice(f.attribute, filterFlags):
+		if f.op == "" {
+			f.op = "="
+		}
+		if f.op != "=" && f.op != "!=" {
+			return f, fmt.Errorf("op of %s should be = or !=", f.attribute)
+		}
+		flag, err := cast.ToBoolE(value)
+		if err != nil {
+			return f, fmt.Errorf("value of %s should be true or false", f.attribute)
+		}
+		f.flag = flag
+	case stringInStringSlice(f.attribute, filterTexts):
+		if f.op == "" {
+			f.op = "contains"
+		}
+		if f.op != "contains" && f.op != "not_contains" {
+			return f, fmt.Errorf("op of %s should be contains or not_contains", f.attribute)
+		}
+		keywords, err := cast.ToStringSliceE(value)
+		if err != nil || len(keywords) == 0 {
+			return f, fmt.Errorf("value of %s should be a keyword or a list of keywords", f.attribute)
+		}
+		for _, keyword := range keywords {
+			f.keywords = append(f.keywords, strings.ToLower(keyword))
+		}
+	case stringInStringSlice(f.attribute, filterNumbers):
+		if f.op == "" {
+			f.op = "="
+		}
+		if !stringInStringSlice(f.op, filterNumberOps) {
+			return f, fmt.Errorf("op of %s should be one of %s", f.attribute, strings.Join(filterNumberOps, " "))
+		}
+		if f.attribute == "post_age" {
+			age, err := time.ParseDuration(cast.ToString(value))
+			if err != nil {
+				return f, fmt.Errorf("value of post_age should be a duration like 72h")
+			}
+			f.number = age.Seconds()
+		} else {
+			number, err := cast.ToFloat64E(value)
+			if err != nil {
+				return f, fmt.Errorf("value of %s should be a number", f.attribute)
+			}
+			f.number = number
+		}
+	default:
+		attributes := append(append(append([]string(nil), filterNumbers...), filterFlags...), filterTexts...)
+		return f, fmt.Errorf("attribute should be one of %s, got %q", strings.Join(attributes, ", "), f.attribute)
+	}
+	return f, nil
+}
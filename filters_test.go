@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/spf13/viper"
+)
+
+func TestParseFilterCondition(t *testing.T) {
+	tests := []struct {
+		fields map[string]interface{}
+		want   filterCondition
+		err    bool
+	}{
+		{fields: map[string]interface{}{"attribute": "followers", "op": ">", "value": 1000}, want: filterCondition{attribute: "followers", op: ">", number: 1000}},
+		{fields: map[string]interface{}{"attribute": "ratio", "value": "1.5"}, want: filterCondition{attribute: "ratio", op: "=", number: 1.5}},
+		{fields: map[string]interface{}{"attribute": "post_age", "op": "<", "value": "72h"}, want: filterCondition{attribute: "post_age", op: "<", number: 72 * 3600}},
+		{fields: map[string]interface{}{"attribute": "is_private", "value": true}, want: filterCondition{attribute: "is_private", op: "=", flag: true}},
+		{fields: map[string]interface{}{"attribute": "whitelisted", "op": "!=", "value": "false"}, want: filterCondition{attribute: "whitelisted", op: "!=", flag: false}},
+		{fields: map[string]interface{}{"attribute": "bio", "value": []interface{}{"Shop", "SALE"}}, want: filterCondition{attribute: "bio", op: "contains", keywords: []string{"shop", "sale"}}},
+		{fields: map[string]interface{}{"attribute": "caption", "op": "not_contains", "value": "cat"}, want: filterCondition{attribute: "caption", op: "not_contains", keywords: []string{"cat"}}},
+
+		{fields: map[string]interface{}{"attribute": "age", "value": 1}, err: true},
+		{fields: map[string]interface{}{"attribute": "followers", "op": "contains", "value": 1}, err: true},
+		{fields: map[string]interface{}{"attribute": "followers", "value": "many"}, err: true},
+		{fields: map[string]interface{}{"attribute": "post_age", "value": "3 days"}, err: true},
+		{fields: map[string]interface{}{"attribute": "is_private", "op": ">", "value": true}, err: true},
+		{fields: map[string]interface{}{"attribute": "is_private", "value": "maybe"}, err: true},
+		{fields: map[string]interface{}{"attribute": "bio", "op": "=", "value": "shop"}, err: true},
+		{fields: map[string]interface{}{"attribute": "bio", "value": []interface{}{}}, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseFilterCondition(test.fields)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: no error", test.fields)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", test.fields, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %+v, want %+v", test.fields, got, test.want)
+		}
+	}
+}
+
+// setFilterRules applies the rules like the "filters" section of config
+func setFilterRules(t *testing.T, rules []map[string]interface{}) {
+	viper.Set("filters", rules)
+	parsed, err := readFilterRules(viper.GetViper())
+	if err != nil {
+		t.Fatal(err)
+	}
+	filterRules = parsed
+	filtersConfigured = true
+}
+
+func resetFilterRules() {
+	viper.Set("filters", nil)
+	filterRules = nil
+	filtersConfigured = false
+}
+
+func TestFilterCandidateFirstMatchWins(t *testing.T) {
+	setFilterRules(t, []map[string]interface{}{
+		{"attribute": "is_verified", "value": true, "action": filterFollow, "reason": "verified"},
+		{"attribute": "followers", "op": ">", "value": 1000, "action": filterSkip, "reason": "too popular"},
+		{"attribute": "followers", "op": ">", "value": 100, "action": filterLikeOnly, "reason": "popular"},
+		{"attribute": "likes", "op": ">", "value": 50, "and": []interface{}{map[string]interface{}{"attribute": "caption", "value": "sale"}}, "action": filterSkip, "reason": "ad"},
+	})
+	defer resetFilterRules()
+
+	tests := []struct {
+		user   goinsta.User
+		item   *goinsta.Item
+		action string
+		reason string
+	}{
+		{user: goinsta.User{FollowerCount: 5000, IsVerified: true}, action: filterFollow, reason: "verified"},
+		{user: goinsta.User{FollowerCount: 5000}, action: filterSkip, reason: "too popular"},
+		{user: goinsta.User{FollowerCount: 500}, action: filterLikeOnly, reason: "popular"},
+		{user: goinsta.User{FollowerCount: 10}, item: &goinsta.Item{Likes: 100, Caption: goinsta.Caption{Text: "Big SALE"}}, action: filterSkip, reason: "ad"},
+		{user: goinsta.User{FollowerCount: 10}, item: &goinsta.Item{Likes: 10, Caption: goinsta.Caption{Text: "Big SALE"}}, action: filterFollow},
+		// post attributes don't match outside of tags
+		{user: goinsta.User{FollowerCount: 10}, action: filterFollow},
+	}
+
+	for i, test := range tests {
+		action, reason := filterCandidate(nil, &test.user, test.item)
+		if action != test.action || reason != test.reason {
+			t.Errorf("%d: got %s (%s), want %s (%s)", i, action, reason, test.action, test.reason)
+		}
+	}
+}
+
+func TestDefaultFilters(t *testing.T) {
+	testDB, _, cleanup := newTestEnv(t)
+	defer cleanup()
+	setFilterRules(t, defaultFilters)
+	defer resetFilterRules()
+
+	// main opens its own database, the global one is not set outside of tests
+	db = nil
+
+	if _, err := changeList(testDB, "whitelist", []string{"friend"}, false, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if action, _ := filterCandidate(testDB, &goinsta.User{Username: "friend"}, nil); action != filterSkip {
+		t.Errorf("whitelisted user: got %s, want %s", action, filterSkip)
+	}
+	if action, _ := filterCandidate(testDB, &goinsta.User{Username: "stranger", IsPrivate: true}, nil); action != filterSkip {
+		t.Errorf("private user: got %s, want %s", action, filterSkip)
+	}
+	if action, _ := filterCandidate(testDB, &goinsta.User{Username: "stranger"}, nil); action != filterFollow {
+		t.Errorf("public user: got %s, want %s", action, filterFollow)
+	}
+}
+
+func TestFilterUserFetchesProfileOnce(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+	setFilterRules(t, []map[string]interface{}{
+		{"attribute": "followers", "op": "<", "value": 1, "action": filterSkip, "reason": "no followers"},
+	})
+	defer resetFilterRules()
+
+	fake.AddUser(goinsta.User{Username: "alice"})
+	fake.AddUser(goinsta.User{Username: "bob"})
+	fake.SetFollows("bob", "alice")
+	fake.Fail("Profile carol", errors.New("not found"))
+	ig := withContext(context.Background(), fake)
+
+	// restored from a session by username only
+	alice := goinsta.User{Username: "alice"}
+	if action, _, err := filterUser(context.Background(), db, ig, &alice); err != nil || action != filterFollow {
+		t.Errorf("alice: got %s, %v, want %s", action, err, filterFollow)
+	}
+	if alice.ID == 0 || alice.FollowerCount != 1 {
+		t.Errorf("alice profile is not loaded: %+v", alice)
+	}
+
+	bob := goinsta.User{ID: 2, Username: "bob"}
+	if action, reason, err := filterUser(context.Background(), db, ig, &bob); err != nil || action != filterSkip {
+		t.Errorf("bob: got %s (%s), %v, want %s", action, reason, err, filterSkip)
+	}
+
+	carol := goinsta.User{Username: "carol"}
+	if action, _, err := filterUser(context.Background(), db, ig, &carol); err != nil || action != filterSkip {
+		t.Errorf("carol: got %s, %v, want %s", action, err, filterSkip)
+	}
+
+	if want := []string{"alice", "bob", "carol"}; !reflect.DeepEqual(fake.Lookups, want) {
+		t.Errorf("fetched %v, want %v", fake.Lookups, want)
+	}
+}
+
+func TestFilterUserWithShortRecord(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+	setFilterRules(t, defaultFilters)
+	defer resetFilterRules()
+
+	user := goinsta.User{ID: 10, Username: "alice", IsPrivate: true}
+	action, _, err := filterUser(context.Background(), db, withContext(context.Background(), fake), &user)
+	if err != nil || action != filterSkip {
+		t.Errorf("got %s, %v, want %s", action, err, filterSkip)
+	}
+	if len(fake.Lookups) != 0 {
+		t.Errorf("fetched %v, the list record is enough", fake.Lookups)
+	}
+}
+
+func TestLoopTagsWithRulesIgnoresTagThresholds(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+	setTestLimits()
+	likeUpperLimit = 5
+	potencyRatio = 100
+	setFilterRules(t, []map[string]interface{}{
+		{"attribute": "followers", "op": ">", "value": 1000, "action": filterSkip, "reason": "too popular"},
+	})
+	defer resetFilterRules()
+
+	if _, err := changeList(db, "tags", []string{"cats"}, false, "test"); err != nil {
+		t.Fatal(err)
+	}
+	addPoster(fake, "cats", "alice", "1")
+
+	if err := loopTags(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []string{"like 1", "follow alice"} {
+		if !stringInStringSlice(action, fake.Actions) {
+			t.Errorf("%q is not done, actions: %v", action, fake.Actions)
+		}
+	}
+}
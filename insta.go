@@ -1,10 +1,10 @@
 package main
 
 import (
+	"context"
 	// "errors"
 	"fmt"
 	// "io/ioutil"
-	"log"
 	"math"
 	"math/rand"
 	"net/http"
@@ -15,8 +15,10 @@ import (
 	"time"
 
 	"github.com/ad/cron"
+	"github.com/ahmdrz/goinsta/v2"
 
 	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
@@ -25,631 +27,550 @@ import (
 // Insta is a goinsta.Instagram instance
 var insta *goinsta.Instagram
 
+// instaClient is used by all tasks to talk to Instagram, it is replaced by a login under loginMu, see currentInstaClient
+var instaClient InstagramClient
+
 var usersInfo = make(map[string]goinsta.User)
 var tagFeed = make(map[string]goinsta.Item)
 
 var reportAsString string
 
-func refollowManager(db *bolt.DB) (startChan chan bool, outerChan, innerChan chan string, stopChan chan bool) {
-	startChan = make(chan bool)
-	outerChan = make(chan string)
-	innerChan = make(chan string)
-	stopChan = make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-startChan:
-				if !refollowIsStarted.IsSet() {
-					refollowIsStarted.Set()
-					go followFollowers(db, innerChan, stopChan)
-					// innerChan <- "start"
-				} else {
-					fmt.Println("can't start task, task already running!")
-				}
-			case msg := <-outerChan:
-				fmt.Println("refollow <- ", msg)
-				//default:
-				//	time.Sleep(100 * time.Millisecond)
+// followFollowers follows users which are followed by username
+func followFollowers(ctx context.Context, db *bolt.DB, username string) error {
+	ig := withContext(ctx, currentInstaClient())
+	tlog := taskLogger("refollow")
+
+	l.Lock()
+	state["refollow"] = 0
+	state["refollow_current"] = 0
+	l.Unlock()
+
+	defer func() {
+		l.RLock()
+		current := state["refollow_current"]
+		l.RUnlock()
+
+		telegramResp <- telegramResponse{fmt.Sprintf("\nRefollowed %d users!", current), "refollow"}
+
+		l.Lock()
+		state["refollow"] = -1
+		l.Unlock()
+	}()
+
+	if err := sleep(ctx, 1*time.Second); err != nil {
+		return err
+	}
+
+	session, resumed := loadSession(ctx, db, "refollow", username)
+
+	var users []goinsta.User
+	if resumed {
+		users = sessionUsers(db, session)
+	} else {
+		user, err := ig.Profile(username)
+		if err != nil {
+			telegramResp <- telegramResponse{fmt.Sprintf("%s", err), "refollow"}
+			return err
+		}
+
+		if user.IsPrivate {
+			if !user.Friendship.Following {
+				telegramResp <- telegramResponse{"User profile is private and we are not following, can't process", "refollow"}
+				return nil
 			}
 		}
-	}()
-	return startChan, outerChan, innerChan, stopChan
-}
 
-func followFollowers(db *bolt.DB, innerChan chan string, stopChan chan bool) {
-	defer refollowIsStarted.UnSet()
-	for {
-		select {
-		case msg := <-innerChan:
-			fmt.Println("refollow <- ", msg)
-			go func() {
-				l.Lock()
-				state["refollow"] = 0
-				l.Unlock()
-
-				time.Sleep(1 * time.Second)
-				username := msg
-				user, err := insta.Profiles.ByName(username)
-				if err != nil {
-					telegramResp <- telegramResponse{fmt.Sprintf("%s", err), "refollow"}
-					stopChan <- true
-					return
-				}
+		followers := ig.Following(user)
+		users = allUsers(followers)
+		if err := followers.Error(); err != nil {
+			return err
+		}
 
-				if user.IsPrivate {
-					// userFriendShip, err := insta.UserFriendShip(user.User.ID)
-					// check(err)
-					if !user.Friendship.Following {
-						telegramResp <- telegramResponse{"User profile is private and we are not following, can't process", "refollow"}
-						stopChan <- true
-						return
-					}
-				}
+		if len(users) > 0 {
+			rand.Seed(time.Now().UnixNano()) // do it once during app initialization
+			shuffle(users)
+		}
+	}
 
-				followers := user.Following()
-				for followers.Next() {
-					users := followers.Users
+	var limit = budgetRemaining(db, "follow")
 
-					if len(users) > 0 {
-						rand.Seed(time.Now().UnixNano()) // do it once during app initialization
-						shuffle(users)
-					}
+	var allCount = int(math.Min(float64(len(users)), float64(limit)))
+	if resumed {
+		allCount = int(math.Min(float64(session.AllCount), float64(session.Current+limit)))
+	} else {
+		session.AllCount = allCount
+	}
+	switch {
+	case allCount == 0 && len(users) > 0:
+		telegramResp <- telegramResponse{"Follow limit reached :(", "refollow"}
+	case allCount <= 0:
+		telegramResp <- telegramResponse{"Followers not found :(", "refollow"}
+	default:
+		var current = session.Current
+
+		telegramResp <- telegramResponse{fmt.Sprintf("%d users will be followed", allCount-current), "refollow"}
+
+		for index := range users {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if current >= allCount {
+				break
+			}
 
-					var limit = viper.GetInt("limits.maxSync")
-					if limit <= 0 || limit >= 1000 {
-						limit = 1000
-					}
+			action, reason, err := filterUser(ctx, db, ig, &users[index])
+			if err != nil {
+				return err
+			}
+			if action != filterFollow {
+				tlog.WithFields(logrus.Fields{"username": users[index].Username, "reason": reason}).Debug("filtered out, skipping")
+			} else {
+				previoslyFollowed, _ := getFollowed(db, users[index].Username)
+				if previoslyFollowed != "" {
+					tlog.WithFields(logrus.Fields{"username": users[index].Username, "followed": previoslyFollowed}).Debug("previously followed, skipping")
+				} else {
+					session.Remaining = usernames(users[index:])
+					session.Current = current
+					checkpoint(db, session)
 
-					today, _ := getStats(db, "refollow")
-					if today > 0 {
-						limit = limit - today
+					if err := takeBudget(ctx, db, "follow"); err != nil {
+						return err
 					}
 
-					var allCount = int(math.Min(float64(len(users)), float64(limit)))
-					switch {
-					case allCount == 0 && len(users) > 0:
-						telegramResp <- telegramResponse{"Follow limit reached :(", "refollow"}
-					case allCount <= 0:
-						telegramResp <- telegramResponse{"Followers not found :(", "refollow"}
-					default:
-						var current = 0
-
-						telegramResp <- telegramResponse{fmt.Sprintf("%d users will be followed", allCount), "refollow"}
-
-						for index := range users {
-							if !refollowIsStarted.IsSet() {
-								stopChan <- true
-								return
-							}
-							if current >= limit {
-								continue
-							}
+					current++
 
-							if users[index].IsPrivate {
-								log.Printf("%s is private, skipping\n", users[index].Username)
-							} else {
-								previoslyFollowed, _ := getFollowed(db, users[index].Username)
-								if previoslyFollowed != "" {
-									log.Printf("%s previously followed at %s, skipping\n", users[index].Username, previoslyFollowed)
-								} else {
-									current++
-
-									l.Lock()
-									state["refollow"] = int(current * 100 / allCount)
-									state["refollow_current"] = current
-									state["refollow_all_count"] = allCount
-									l.Unlock()
-
-									text := fmt.Sprintf("[%d/%d] refollowing %s (%d%%)", state["refollow_current"], state["refollow_all_count"], users[index].Username, state["refollow"])
-									telegramResp <- telegramResponse{text, "refollow"}
-
-									if !*dev {
-										users[index].Follow()
-										// insta.Follow(users[index].ID)
-										setFollowed(db, users[index].Username)
-										incStats(db, "follow")
-										incStats(db, "refollow")
-										time.Sleep(16 * time.Second)
-									} else {
-										time.Sleep(2 * time.Second)
-									}
-								}
-							}
+					l.Lock()
+					state["refollow"] = int(current * 100 / allCount)
+					state["refollow_current"] = current
+					state["refollow_all_count"] = allCount
+					l.Unlock()
+
+					text := fmt.Sprintf("[%d/%d] refollowing %s (%d%%)", current, allCount, users[index].Username, current*100/allCount)
+					telegramResp <- telegramResponse{text, "refollow"}
+
+					if !*dev {
+						err := ig.Follow(&users[index])
+						audit(db, "follow", users[index].Username, "refollow", "@"+username, err)
+						if err != nil {
+							refundBudget(db, "follow")
+							tlog.WithFields(logrus.Fields{"username": users[index].Username, "action": "follow"}).WithError(err).Error("follow failed")
+						} else {
+							setFollowed(db, users[index].Username, "refollow", username, users[index].Friendship.OutgoingRequest)
+							incStats(db, "follow", "refollow")
 						}
+					} else {
+						audit(db, "follow", users[index].Username, "refollow", "@"+username, nil)
 					}
-					stopChan <- true
 				}
-			}()
-		case <-stopChan:
-			telegramResp <- telegramResponse{fmt.Sprintf("\nRefollowed %d users!", state["refollow_current"]), "refollow"}
-
-			l.Lock()
-			editMessage["refollow"] = make(map[int]int)
-			state["refollow"] = -1
-			l.Unlock()
-			return
-			//default:
-			//	time.Sleep(100 * time.Millisecond)
+			}
 		}
 	}
+
+	return nil
 }
 
-func followLikersManager(db *bolt.DB) (startChan chan bool, outerChan, innerChan chan string, stopChan chan bool) {
-	startChan = make(chan bool)
-	outerChan = make(chan string)
-	innerChan = make(chan string)
-	stopChan = make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-startChan:
-				if !followLikersIsStarted.IsSet() {
-					followLikersIsStarted.Set()
-					go followLikers(db, innerChan, stopChan)
-					// innerChan <- "start"
-				} else {
-					fmt.Println("can't start task, task already running!")
-				}
-			case msg := <-outerChan:
-				fmt.Println("followLikers <- ", msg)
-				//default:
-				//	time.Sleep(100 * time.Millisecond)
-			}
-		}
+// followLikers follows users who liked the post at link
+func followLikers(ctx context.Context, db *bolt.DB, link string) error {
+	ig := withContext(ctx, currentInstaClient())
+	tlog := taskLogger("followLikers")
+
+	l.Lock()
+	state["followLikers"] = 0
+	state["followLikers_current"] = 0
+	l.Unlock()
+
+	defer func() {
+		l.RLock()
+		current := state["followLikers_current"]
+		l.RUnlock()
+
+		telegramResp <- telegramResponse{fmt.Sprintf("\nfollowed %d users!", current), "followLikers"}
+
+		l.Lock()
+		state["followLikers"] = -1
+		l.Unlock()
 	}()
-	return startChan, outerChan, innerChan, stopChan
-}
 
-func followLikers(db *bolt.DB, innerChan chan string, stopChan chan bool) {
-	defer followLikersIsStarted.UnSet()
-	for {
-		select {
-		case msg := <-innerChan:
-			fmt.Println("followLikers <- ", msg)
-			go func() {
-				l.Lock()
-				state["followLikers"] = 0
-				l.Unlock()
-
-				time.Sleep(1 * time.Second)
-
-				if len(msg) > 0 {
-					u, err := url.Parse(msg)
-					if err == nil {
-						test := strings.TrimPrefix(u.Path, "/p/")
-						test = strings.TrimSuffix(test, "/")
+	if err := sleep(ctx, 1*time.Second); err != nil {
+		return err
+	}
 
-						mediaID, err := goinsta.MediaIDFromShortID(test)
-						if err != nil {
-							fmt.Println(err)
-						} else {
-							media, err := insta.GetMedia(mediaID)
-							if err != nil {
-								fmt.Println(err)
-							} else {
-								users := media.Items[0].Likers
-								if len(users) > 0 {
-									println(fmt.Sprintf("found %d likers", len(users)))
-									rand.Seed(time.Now().UnixNano()) // do it once during app initialization
-									shuffle(users)
-
-									var limit = viper.GetInt("limits.maxSync")
-									if limit <= 0 || limit >= 1000 {
-										limit = 1000
-									}
+	if len(link) == 0 {
+		return nil
+	}
 
-									today, _ := getStats(db, "followLikers")
-									if today > 0 {
-										limit = limit - today
-									}
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
 
-									var allCount = int(math.Min(float64(len(users)), float64(limit)))
-									switch {
-									case allCount == 0 && len(users) > 0:
-										telegramResp <- telegramResponse{"Follow limit reached :(", "followLikers"}
-									case allCount <= 0:
-										telegramResp <- telegramResponse{"Likers not found :(", "followLikers"}
-									default:
-										var current = 0
-
-										telegramResp <- telegramResponse{fmt.Sprintf("%d users will be followed", allCount), "followLikers"}
-
-										for index := range users {
-											if !followLikersIsStarted.IsSet() {
-												stopChan <- true
-												return
-											}
-											if current >= limit {
-												continue
-											}
-
-											if users[index].IsPrivate {
-												log.Printf("%s is private, skipping\n", users[index].Username)
-											} else {
-												previoslyFollowed, _ := getFollowed(db, users[index].Username)
-												if previoslyFollowed != "" {
-													log.Printf("%s previously followed at %s, skipping\n", users[index].Username, previoslyFollowed)
-												} else {
-													current++
-
-													l.Lock()
-													state["followLikers"] = int(current * 100 / allCount)
-													state["followLikers_current"] = current
-													state["followLikers_all_count"] = allCount
-													l.Unlock()
-
-													text := fmt.Sprintf("[%d/%d] following %s (%d%%)", state["followLikers_current"], state["followLikers_all_count"], users[index].Username, state["followLikers"])
-													telegramResp <- telegramResponse{text, "followLikers"}
-
-													if !*dev {
-														users[index].Follow()
-														// insta.Follow(users[index].ID)
-														setFollowed(db, users[index].Username)
-														incStats(db, "follow")
-														incStats(db, "followLikers")
-														time.Sleep(16 * time.Second)
-													} else {
-														time.Sleep(2 * time.Second)
-													}
-												}
-											}
-										}
-									}
-								} else {
-									println("likers not found")
-								}
-							}
-						}
-					}
-				}
-				stopChan <- true
-			}()
-		case <-stopChan:
-			telegramResp <- telegramResponse{fmt.Sprintf("\nfollowed %d users!", state["followLikers_current"]), "followLikers"}
+	test := strings.TrimPrefix(u.Path, "/p/")
+	test = strings.TrimSuffix(test, "/")
 
-			l.Lock()
-			editMessage["followLikers"] = make(map[int]int)
-			state["followLikers"] = -1
-			l.Unlock()
-			return
-			//default:
-			//	time.Sleep(100 * time.Millisecond)
+	mediaID, err := goinsta.MediaIDFromShortID(test)
+	if err != nil {
+		tlog.WithError(err).Error("invalid post link")
+		return err
+	}
+
+	session, resumed := loadSession(ctx, db, "followLikers", link)
+
+	var users []goinsta.User
+	if resumed {
+		users = sessionUsers(db, session)
+	} else {
+		media, err := ig.Media(mediaID)
+		if err != nil {
+			tlog.WithError(err).Error("can't get the post")
+			return err
 		}
+
+		users = media.Likers
+		if len(users) == 0 {
+			tlog.Info("likers not found")
+			return nil
+		}
+
+		tlog.WithField("count", len(users)).Info("found likers")
+		rand.Seed(time.Now().UnixNano()) // do it once during app initialization
+		shuffle(users)
 	}
-}
 
-func unfollowManager(db *bolt.DB) (startChan chan bool, outerChan, innerChan chan string, stopChan chan bool) {
-	startChan = make(chan bool)
-	outerChan = make(chan string)
-	innerChan = make(chan string)
-	stopChan = make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-startChan:
-				if !unfollowIsStarted.IsSet() {
-					unfollowIsStarted.Set()
-					go syncFollowers(db, innerChan, stopChan)
-					innerChan <- "start"
+	var limit = budgetRemaining(db, "follow")
+
+	var allCount = int(math.Min(float64(len(users)), float64(limit)))
+	if resumed {
+		allCount = int(math.Min(float64(session.AllCount), float64(session.Current+limit)))
+	} else {
+		session.AllCount = allCount
+	}
+	switch {
+	case allCount == 0 && len(users) > 0:
+		telegramResp <- telegramResponse{"Follow limit reached :(", "followLikers"}
+	case allCount <= 0:
+		telegramResp <- telegramResponse{"Likers not found :(", "followLikers"}
+	default:
+		var current = session.Current
+
+		telegramResp <- telegramResponse{fmt.Sprintf("%d users will be followed", allCount-current), "followLikers"}
+
+		for index := range users {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if current >= allCount {
+				break
+			}
+
+			action, reason, err := filterUser(ctx, db, ig, &users[index])
+			if err != nil {
+				return err
+			}
+			if action != filterFollow {
+				tlog.WithFields(logrus.Fields{"username": users[index].Username, "reason": reason}).Debug("filtered out, skipping")
+			} else {
+				previoslyFollowed, _ := getFollowed(db, users[index].Username)
+				if previoslyFollowed != "" {
+					tlog.WithFields(logrus.Fields{"username": users[index].Username, "followed": previoslyFollowed}).Debug("previously followed, skipping")
 				} else {
-					fmt.Println("can't start task, task already running!")
+					session.Remaining = usernames(users[index:])
+					session.Current = current
+					checkpoint(db, session)
+
+					if err := takeBudget(ctx, db, "follow"); err != nil {
+						return err
+					}
+
+					current++
+
+					l.Lock()
+					state["followLikers"] = int(current * 100 / allCount)
+					state["followLikers_current"] = current
+					state["followLikers_all_count"] = allCount
+					l.Unlock()
+
+					text := fmt.Sprintf("[%d/%d] following %s (%d%%)", current, allCount, users[index].Username, current*100/allCount)
+					telegramResp <- telegramResponse{text, "followLikers"}
+
+					if !*dev {
+						err := ig.Follow(&users[index])
+						audit(db, "follow", users[index].Username, "followLikers", link, err)
+						if err != nil {
+							refundBudget(db, "follow")
+							tlog.WithFields(logrus.Fields{"username": users[index].Username, "action": "follow"}).WithError(err).Error("follow failed")
+						} else {
+							setFollowed(db, users[index].Username, "followlikers", link, users[index].Friendship.OutgoingRequest)
+							incStats(db, "follow", "followlikers")
+						}
+					} else {
+						audit(db, "follow", users[index].Username, "followLikers", link, nil)
+					}
 				}
-			case msg := <-outerChan:
-				fmt.Println("unfollow <- ", msg)
-				//default:
-				//	time.Sleep(100 * time.Millisecond)
 			}
 		}
-	}()
-	return startChan, outerChan, innerChan, stopChan
+	}
+
+	return nil
 }
 
-func syncFollowers(db *bolt.DB, innerChan chan string, stopChan chan bool) {
-	defer unfollowIsStarted.UnSet()
+// syncFollowers unfollows users who don't follow us back or don't like our posts
+func syncFollowers(ctx context.Context, db *bolt.DB) error {
+	ig := withContext(ctx, currentInstaClient())
+	tlog := taskLogger("unfollow")
 
 	resultError := ""
 
-	for {
-		select {
-		case msg := <-innerChan:
-			fmt.Println("unfollow <- ", msg)
-			go func() {
+	l.Lock()
+	state["unfollow"] = 0
+	state["unfollow_current"] = 0
+	l.Unlock()
+
+	defer func() {
+		l.RLock()
+		current := state["unfollow_current"]
+		l.RUnlock()
 
-				l.Lock()
-				state["unfollow"] = 0
-				l.Unlock()
+		if resultError != "" {
+			telegramResp <- telegramResponse{fmt.Sprintf("\nUnfollowed %d users are not following you back!\n%s", current, resultError), "unfollow"}
+		} else {
+			if current == 0 {
+				telegramResp <- telegramResponse{fmt.Sprintf("No one was unfollowed"), "unfollow"}
+			} else {
+				telegramResp <- telegramResponse{fmt.Sprintf("\nUnfollowed %d users are not following you back!", current), "unfollow"}
+			}
+		}
+
+		l.Lock()
+		state["unfollow_current"] = 0
+		state["unfollow_all_count"] = 0
+		state["unfollow"] = -1
+		l.Unlock()
+	}()
 
-				time.Sleep(1 * time.Second)
+	if err := sleep(ctx, 1*time.Second); err != nil {
+		return err
+	}
 
-				var limit = viper.GetInt("limits.max_unfollow_per_day")
-				today, _ := getStats(db, "unfollow")
+	var limit = viper.GetInt("limits.max_unfollow_per_day")
+	today, _ := getStats(db, "unfollow", "")
 
-				if limit == 0 || (limit-today) <= 0 {
-					stopChan <- true
-					return
-				}
+	if limit == 0 || (limit-today) <= 0 {
+		return nil
+	}
 
-				telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, receiving following users"), "unfollow"}
+	telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, receiving following users"), "unfollow"}
 
-				user, err := insta.Profiles.ByName(insta.Account.Username)
-				if err != nil {
-					log.Println(err)
-				}
+	user, err := ig.Profile(ig.Username())
+	if err != nil {
+		tlog.WithError(err).Error("can't get own profile")
+		return err
+	}
 
-				following := make([]goinsta.User, 0)
-				usersFollowing := user.Following()
-				for usersFollowing.Next() {
-					for _, user := range usersFollowing.Users {
-						following = append(following, user)
-					}
-				}
+	usersFollowing := ig.Following(user)
+	following := allUsers(usersFollowing)
+	if err := usersFollowing.Error(); err != nil {
+		return err
+	}
 
-				// following := user.Following() // . TotalUserFollowers(user.ID)
-				// if err != nil {
-				// 	fmt.Println(err)
-				// 	return
-				// }
+	telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, receiving followers (%d)", len(following)), "unfollow"}
+	if err := sleep(ctx, 30*time.Second); err != nil {
+		return err
+	}
 
-				// following, err := insta.SelfTotalUserFollowing()
-				// if err != nil {
-				// 	fmt.Println(err)
-				// }
+	usersFollowers := ig.Followers(user)
+	followers := allUsers(usersFollowers)
+	if err := usersFollowers.Error(); err != nil {
+		return err
+	}
 
-				telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, receiving followers (%d)", len(following)), "unfollow"}
-				time.Sleep(30 * time.Second)
+	if err := syncRelationships(db, following, followers); err != nil {
+		tlog.WithError(err).Error("can't sync relationships")
+	}
 
-				followers := make([]goinsta.User, 0)
-				usersFollowers := user.Followers()
-				for usersFollowers.Next() {
-					for _, user := range usersFollowers.Users {
-						followers = append(followers, user)
-					}
-				}
-				// followers := user.Followers() //insta.SelfTotalUserFollowers()
-				// if err != nil {
-				// 	fmt.Println(err)
-				// }
+	telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, checking delay before unfollowed (%d/%d)", len(following), len(followers)), "unfollow"}
+	if err := sleep(ctx, 30*time.Second); err != nil {
+		return err
+	}
 
-				telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, checking delay before unfollowed (%d/%d)", len(following), len(followers)), "unfollow"}
-				time.Sleep(30 * time.Second)
+	var daysBeforeUnfollow = viper.GetInt("limits.days_before_unfollow")
+	if daysBeforeUnfollow <= 0 || daysBeforeUnfollow >= 30 {
+		daysBeforeUnfollow = 3
+	}
 
-				var daysBeforeUnfollow = viper.GetInt("limits.days_before_unfollow")
-				if daysBeforeUnfollow <= 0 || daysBeforeUnfollow >= 30 {
-					daysBeforeUnfollow = 3
+	var users []goinsta.User
+	for index := range following {
+		if !contains(followers, following[index]) {
+			rel, err := getRelationship(db, following[index].Username)
+			if err != nil {
+				tlog.WithField("username", following[index].Username).WithError(err).Error("can't get relationship")
+				continue
+			}
+			if rel != nil && !rel.FollowedAt.IsZero() {
+				duration := time.Since(rel.FollowedAt)
+				if int(duration.Hours()) < (24 * daysBeforeUnfollow) {
+					tlog.WithFields(logrus.Fields{"username": following[index].Username, "hours": int(duration.Hours())}).Debug("followed recently, skipping")
+					continue
 				}
+			}
+			users = append(users, following[index])
+		}
+	}
 
-				// type User struct {
-				// 	ID         int64  `json:"pk"`
-				// 	Username   string `json:"username"`
-				// 	ProfilePic string `json:"profile_pic"`
-				// }
+	telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, checking last likers (%d)", len(users)), "unfollow"}
+	if err := sleep(ctx, 30*time.Second); err != nil {
+		return err
+	}
 
-				var users []goinsta.User
-				for index := range following {
-					if !contains(followers, following[index]) {
-						previoslyFollowed, _ := getFollowed(db, following[index].Username)
-						if previoslyFollowed != "" {
-							t, err := time.Parse("20060102", previoslyFollowed)
+	lastLikers := getLastLikers(ig)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(lastLikers) > 0 {
+		if len(following) > 0 {
+			telegramResp <- telegramResponse{fmt.Sprintf("Found %d following, %d likers for last 10 posts\n", len(following), len(lastLikers)), "unfollow"}
+			var notLikers []goinsta.User
+			for index := range following {
+				if !stringInStringSlice(following[index].Username, lastLikers) {
+					notLikers = append(notLikers, following[index])
+				}
+			}
 
-							if err != nil {
-								fmt.Println(err)
-							} else {
-								duration := time.Since(t)
-								if int(duration.Hours()) < (24 * daysBeforeUnfollow) {
-									fmt.Printf("%s not followed us less then %f hours, skipping!\n", following[index].Username, duration.Hours())
-									continue
-								} else {
-									users = append(users, following[index])
-								}
-							}
-						} else {
-							users = append(users, following[index])
-						}
+			if len(notLikers) > 0 {
+				for index := range notLikers {
+					rel, err := getRelationship(db, notLikers[index].Username)
+					if err != nil {
+						tlog.WithField("username", notLikers[index].Username).WithError(err).Error("can't get relationship")
+						continue
+					}
+					if rel != nil && !rel.FollowedAt.IsZero() && time.Since(rel.FollowedAt) < time.Duration(daysBeforeUnfollow)*24*time.Hour {
+						continue
+					}
+					if !contains(users, notLikers[index]) {
+						users = append(users, notLikers[index])
 					}
 				}
+			}
+		}
+	}
 
-				telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow, checking last likers (%d)", len(users)), "unfollow"}
-				time.Sleep(30 * time.Second)
-
-				lastLikers := getLastLikers()
-				if len(lastLikers) > 0 {
-					if len(following) > 0 {
-						telegramResp <- telegramResponse{fmt.Sprintf("Found %d following, %d likers for last 10 posts\n", len(following), len(lastLikers)), "unfollow"}
-						var notLikers []goinsta.User
-						for index := range following {
-							if !stringInStringSlice(following[index].Username, lastLikers) {
-								notLikers = append(notLikers, following[index])
-							}
-						}
+	telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow (%d)", len(users)), "unfollow"}
+	if err := sleep(ctx, 30*time.Second); err != nil {
+		return err
+	}
 
-						if len(notLikers) > 0 {
-							for index := range notLikers {
-								previoslyFollowed, _ := getFollowed(db, notLikers[index].Username)
-								if previoslyFollowed != "" {
-									t, err := time.Parse("20060102", previoslyFollowed)
-
-									if err != nil {
-										fmt.Println(err)
-									} else {
-										duration := time.Since(t)
-										if int(duration.Hours()) < (24 * daysBeforeUnfollow) {
-
-										} else {
-											if !contains(users, notLikers[index]) {
-												users = append(users, notLikers[index])
-											}
-										}
-									}
-								} else {
-									if !contains(users, notLikers[index]) {
-										users = append(users, notLikers[index])
-									}
-								}
-							}
-						}
-					}
-				}
+	if limit <= 0 || limit >= 1000 {
+		limit = 1000
+	}
 
-				telegramResp <- telegramResponse{fmt.Sprintf("Preparing to unfollow (%d)", len(users)), "unfollow"}
-				time.Sleep(30 * time.Second)
+	if today > 0 {
+		limit = limit - today
+	}
 
-				if limit <= 0 || limit >= 1000 {
-					limit = 1000
-				}
+	var current = 0
+	var allCount = int(math.Min(float64(len(users)), float64(limit)))
+	if allCount > 0 {
+		telegramResp <- telegramResponse{fmt.Sprintf("%d will be unfollowed", allCount), "unfollow"}
 
-				if today > 0 {
-					limit = limit - today
-				}
+		for index := range users {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
-				var current = 0
-				var allCount = int(math.Min(float64(len(users)), float64(limit)))
-				if allCount > 0 {
-					telegramResp <- telegramResponse{fmt.Sprintf("%d will be unfollowed", allCount), "unfollow"}
+			if current >= limit {
+				continue
+			}
 
-					for index := range users {
-						if !unfollowIsStarted.IsSet() {
-							stopChan <- true
-							return
-						}
+			if inList(db, "whitelist", users[index].Username) {
+				telegramResp <- telegramResponse{fmt.Sprintf("[%d/%d] Skip Unfollowing %s (%d%%), in white list\n", current, allCount, users[index].Username, current*100/allCount), "unfollow"}
+				continue
+			}
 
-						if current >= limit {
-							continue
-						}
+			if err := takeBudget(ctx, db, "unfollow"); err != nil {
+				return err
+			}
 
-						if stringInStringSlice(users[index].Username, whiteList) {
-							telegramResp <- telegramResponse{fmt.Sprintf("[%d/%d] Skip Unfollowing %s (%d%%), in white list\n", state["unfollow_current"], state["unfollow_all_count"], users[index].Username, state["unfollow"]), "unfollow"}
-							continue
-						}
+			current++
+			l.Lock()
+			state["unfollow"] = int(current * 100 / allCount)
+			state["unfollow_current"] = current
+			state["unfollow_all_count"] = allCount
+			l.Unlock()
 
-						current++
+			telegramResp <- telegramResponse{fmt.Sprintf("[%d/%d] Unfollowing %s (%d%%)\n", current, allCount, users[index].Username, current*100/allCount), "unfollow"}
+			if !*dev {
+				err := ig.Unfollow(&users[index])
+				audit(db, "unfollow", users[index].Username, "unfollow", "sync", err)
+				if err != nil {
+					refundBudget(db, "unfollow")
+					if err == context.Canceled {
+						return err
+					}
+					if isBlockingError(err) {
+						resultError = "/unfollow stopped: " + classifyError(err)
 						l.Lock()
-						state["unfollow"] = int(current * 100 / allCount)
-						state["unfollow_current"] = current
-						state["unfollow_all_count"] = allCount
+						state["unfollow_current"]--
 						l.Unlock()
-
-						telegramResp <- telegramResponse{fmt.Sprintf("[%d/%d] Unfollowing %s (%d%%)\n", state["unfollow_current"], state["unfollow_all_count"], users[index].Username, state["unfollow"]), "unfollow"}
-						if !*dev {
-							err := users[index].Unfollow() //insta.UnFollow(users[index].ID)
-							if err != nil {
-								// fmt.Println(err.Error())
-								if err.Error() == "fail: feedback_required ()" {
-									resultError = "/unfollow stopped: feedback_required"
-									l.Lock()
-									state["unfollow_current"]--
-									l.Unlock()
-									// telegramResp <- telegramResponse{fmt.Sprintf(), "unfollow"}
-									break
-								} else {
-									fmt.Printf("can't unfollow %s (error: %s)", users[index].Username, err)
-									time.Sleep(60 * time.Second)
-								}
-							} else {
-								setFollowed(db, users[index].Username)
-								incStats(db, "unfollow")
-
-								time.Sleep(30 * time.Second)
-							}
-						} else {
-							time.Sleep(2 * time.Second)
+						break
+					} else {
+						tlog.WithFields(logrus.Fields{"username": users[index].Username, "action": "unfollow"}).WithError(err).Error("unfollow failed")
+						if err := sleep(ctx, 60*time.Second); err != nil {
+							return err
 						}
 					}
-				}
-
-				stopChan <- true
-			}()
-		case <-stopChan:
-
-			if resultError != "" {
-				telegramResp <- telegramResponse{fmt.Sprintf("\nUnfollowed %d users are not following you back!\n%s", state["unfollow_current"], resultError), "unfollow"}
-			} else {
-				if state["unfollow_current"] == 0 {
-					telegramResp <- telegramResponse{fmt.Sprintf("No one was unfollowed"), "unfollow"}
 				} else {
-					telegramResp <- telegramResponse{fmt.Sprintf("\nUnfollowed %d users are not following you back!", state["unfollow_current"]), "unfollow"}
+					setUnfollowed(db, users[index].Username)
+					incStats(db, "unfollow", "sync")
 				}
+			} else {
+				audit(db, "unfollow", users[index].Username, "unfollow", "sync", nil)
 			}
-
-			l.Lock()
-			state["unfollow_current"] = 0
-			state["unfollow_all_count"] = 0
-			state["unfollow"] = -1
-			l.Unlock()
-
-			return
-			//default:
-			//	time.Sleep(100 * time.Millisecond)
 		}
 	}
+
+	return nil
 }
 
-// Logins and saves the session
+// Logins and saves the session, if Instagram asks for a code the login waits for /login code
 func createAndSaveSession() error {
-	insta = goinsta.New(instaUsername, instaPassword)
-	if instaProxy != "" {
-		insta.SetProxy(instaProxy, false)
-	}
+	dropPendingLogin()
 
-	err := insta.Login()
-
-	if err == nil {
-		log.Println("Logged in as", insta.Account.Username)
-		err := insta.Export(".goinsta")
-		if err != nil {
-			log.Println("EXPORT Login", err)
-			// return err
-		}
-		return nil
-
-		// // key := createKey()
-		// bytes, err := store.Export(insta, key)
-		// if err == nil {
-		// 	err = ioutil.WriteFile("session", bytes, 0644)
-		// 	if err == nil {
-		// 		log.Println("Created and saved the session")
-		// 		return nil
-		// 	}
-		// }
+	f, err := newLoginFlow(instaUsername, instaPassword, instaProxy)
+	if err != nil {
+		setLoginStatus(loginFailed, err.Error(), nil)
+		return err
 	}
 
-	return err
+	resp, err := f.login()
+	return finishLogin(f, resp, err)
 }
 
-// login will try to reload a previous session, and will create a new one if it can't
 func login() {
-	err := reloadSession()
-	if err != nil {
-		err = createAndSaveSession()
+	if err := reloadSession(); err == nil {
+		return
+	}
+	if err := createAndSaveSession(); err != nil {
+		logger.WithError(err).Error("can't log in to Instagram")
 	}
 }
 
-// reloadSession will attempt to recover a previous session
 func reloadSession() error {
-	// if _, err := os.Stat("session"); os.IsNotExist(err) {
-	// 	return errors.New("No session found")
-	// }
-	var err error
-	insta, err = goinsta.Import(".goinsta")
+	session, err := importInstaSession()
 	if err != nil {
-		log.Println("ReLogin", err)
+		logger.WithError(err).Info("can't import session")
 		return err
 	}
+	// Import ignores errors of the account sync, an expired session has no username
+	if session.Account.Username == "" {
+		logger.Info("saved session is expired")
+		return fmt.Errorf("saved session is expired")
+	}
+	if instaProxy != "" {
+		session.SetProxy(instaProxy, false)
+	}
 
-	log.Println("ReLogged in as", insta.Account.Username)
-
-	// session, err := ioutil.ReadFile("session")
-	// check(err)
-	// log.Println("A session file exists")
-
-	// key, err := ioutil.ReadFile("key")
-	// check(err)
-
-	// insta, err = store.Import(session, key)
-	// if err != nil {
-	// 	return errors.New("Couldn't recover the session")
-	// }
-
-	// log.Println("Successfully logged in")
+	logger.WithField("username", session.Account.Username).Info("logged in with saved session")
+	setInstaSession(session)
+	setLoginStatus(loginLoggedIn, session.Account.Username+", saved session", nil)
 	return nil
 }
 
@@ -664,389 +585,349 @@ func reloadSession() error {
 // 	return key
 // }
 
-func followManager(db *bolt.DB) (startChan chan bool, outerChan, innerChan chan string, stopChan chan bool) {
-	startChan = make(chan bool)
-	outerChan = make(chan string)
-	innerChan = make(chan string)
-	stopChan = make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-startChan:
-				if !followIsStarted.IsSet() {
-					followIsStarted.Set()
-					go loopTags(db, innerChan, stopChan)
-					innerChan <- "start"
-				} else {
-					fmt.Println("can't start task, task already running!")
-				}
-			case msg := <-outerChan:
-				fmt.Println("follow <- ", msg)
-				//default:
-				//	time.Sleep(100 * time.Millisecond)
-			}
-		}
-	}()
-	return startChan, outerChan, innerChan, stopChan
-}
-
 // Go through all the tags in the list
-func loopTags(db *bolt.DB, innerChan chan string, stopChan chan bool) {
+func loopTags(ctx context.Context, db *bolt.DB) error {
+	ig := withContext(ctx, currentInstaClient())
+	tlog := taskLogger("follow")
+
 	usersInfo = make(map[string]goinsta.User)
 	tagFeed = make(map[string]goinsta.Item)
 
-	followStartedAt := time.Now()
+	session, resumed := loadSession(ctx, db, "follow", "")
 
-	defer followIsStarted.UnSet()
-	for {
-		select {
-		case msg := <-innerChan:
-			fmt.Println("follow <- ", msg)
-			go func() {
+	l.Lock()
+	followStartedAt := session.StartedAt
+	state["follow"] = 0
+	reportAsString = ""
+	l.Unlock()
 
-				l.Lock()
-				followStartedAt = time.Now()
-				state["follow"] = 0
-				reportAsString = ""
-				l.Unlock()
+	defer func() {
+		elapsed := time.Since(followStartedAt)
 
-				time.Sleep(1 * time.Second)
+		if reportAsString != "" {
+			reportAsString += fmt.Sprintf("\n\nFollowing is finished by %s", elapsed.Round(time.Second))
+		} else {
+			reportAsString = "Follow finished"
+		}
 
-				report = make(map[string]map[string]int)
-				likesToAccountPerSession = make(map[string]int)
+		telegramResp <- telegramResponse{reportAsString, "follow"}
 
-				followTestUsername := viper.GetString("user.instagram.follow_test_username")
-				if followTestUsername != "" {
-					user, err := insta.Profiles.ByName(followTestUsername)
-					// user.Sync()
-					// user, err := insta.GetUserByUsername(followTestUsername)
-					if err != nil {
-						log.Printf("test instagram username (%s) not found", followTestUsername)
-					} else {
-						err := user.Follow() //insta.Follow(user.User.ID)
-						if err != nil {
-							text := fmt.Sprintf("test user not followed, /follow canceled. %v", err)
-							telegramResp <- telegramResponse{text, "follow"}
+		l.Lock()
+		state["follow"] = -1
+		reportAsString = ""
+		l.Unlock()
+	}()
 
-							stopChan <- true
-							return
-						} else {
-							log.Printf("test instagram username (%s) followed and unfollowed", user.Username)
-						}
-						// 	user.Unfollow()
-						// 	// insta.UnFollow(user.User.ID)
-					}
-				}
+	if err := sleep(ctx, 1*time.Second); err != nil {
+		return err
+	}
 
-				var allCount = len(tagsList)
-				if allCount > 0 {
-					var current = 0
+	report = make(map[string]map[string]int)
+	likesToAccountPerSession = make(map[string]int)
 
-					shuffle(tagsList)
-					for _, tag := range tagsList {
-						if !followIsStarted.IsSet() {
-							stopChan <- true
-							return
-						}
+	if resumed && session.Report != nil {
+		report = session.Report
+	}
 
-						report[tag] = make(map[string]int)
-						report[tag]["like"] = 0
-						report[tag]["follow"] = 0
-						report[tag]["comment"] = 0
+	// once the daily follow budget is used up the task goes on with likes only
+	followBudgetUsedUp := false
 
-						current++
+	followTestUsername := viper.GetString("user.instagram.follow_test_username")
+	if followTestUsername != "" {
+		user, err := ig.Profile(followTestUsername)
+		if err != nil {
+			if err == context.Canceled {
+				return err
+			}
+			tlog.WithField("username", followTestUsername).Warn("test instagram user not found")
+		} else if err := takeBudget(ctx, db, "follow"); err != nil {
+			if _, ok := err.(*budgetError); !ok {
+				return err
+			}
+			followBudgetUsedUp = true
+			tlog.WithError(err).Info("only liking")
+		} else {
+			err := ig.Follow(user)
+			audit(db, "follow", user.Username, "follow", "test", err)
+			if err != nil {
+				refundBudget(db, "follow")
+				text := fmt.Sprintf("test user not followed, /follow canceled. %v", err)
+				telegramResp <- telegramResponse{text, "follow"}
+
+				return err
+			}
+			tlog.WithField("username", user.Username).Info("test instagram user followed")
+		}
+	}
 
-						l.Lock()
-						state["follow"] = int(current * 100 / allCount)
-						state["follow_current"] = current
-						state["follow_all_count"] = allCount
-						l.Unlock()
+	if !resumed {
+		tags, err := getList(db, "tags")
+		if err != nil {
+			return err
+		}
+		settings, err := getTagSettings(db)
+		if err != nil {
+			return err
+		}
+		session.Remaining = weightedOrder(tags, settings)
+		session.AllCount = len(session.Remaining)
+	}
+	session.Report = report
 
-						// limitsConf := viper.GetStringMap("tags." + tag)
-
-						// // Some converting
-						// limits = map[string]int{
-						// 	"follow":  int(limitsConf["follow"].(float64)),
-						// 	"like":    int(limitsConf["like"].(float64)),
-						// 	"comment": int(limitsConf["comment"].(float64)),
-						// }
-
-						// What we did so far
-						numFollowed = 0
-						numLiked = 0
-						numCommented = 0
-
-						reportAsString = fmt.Sprintf("[%d/%d] %d%%", state["follow_current"], state["follow_all_count"], state["follow"])
-						if current > 1 {
-							l.RLock()
-							elapsed := time.Since(followStartedAt)
-							l.RUnlock()
-							perOne := elapsed.Seconds() / float64(current)
-							duration := time.Duration(time.Duration(perOne*float64(allCount-current+1)) * time.Second)
-							reportAsString += fmt.Sprintf(" ~%s", duration.Round(time.Second))
-						}
+	var allCount = session.AllCount
+	if allCount == 0 || len(session.Remaining) == 0 {
+		return nil
+	}
 
-						for tagItem := range report {
-							if tagItem != tag {
-								if report[tagItem]["like"] > 0 || report[tagItem]["follow"] > 0 || report[tagItem]["comment"] > 0 {
-									reportAsString += fmt.Sprintf("\n#%s: %d 🐾, %d 👍, %d 💌", tagItem, report[tagItem]["follow"], report[tagItem]["like"], report[tagItem]["comment"])
-								} else {
-									reportAsString += fmt.Sprintf("\n#%s: no actions, possibly not enough images", tagItem)
-								}
-							}
-						}
+	var current = session.Current
 
-						reportAsString += fmt.Sprintf("\n#%s: ...", tag)
+	tags := session.Remaining
+	for i, tag := range tags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-						telegramResp <- telegramResponse{reportAsString, "follow"}
-						// browse(tag, db, stopChan)
-						feedTag, err := insta.Feed.Tags(tag)
-						// feedTag.AutoLoadMoreEnabled = true
+		// What we did so far, a resumed tag keeps its counters
+		if report[tag] == nil {
+			report[tag] = make(map[string]int)
+			report[tag]["like"] = 0
+			report[tag]["follow"] = 0
+			report[tag]["comment"] = 0
+		}
+		numFollowed = report[tag]["follow"]
+		numLiked = report[tag]["like"]
+		numCommented = report[tag]["comment"]
 
-						if err != nil {
-							log.Println(err)
-						} else {
-							// success := feedTag.Next()
-
-							// for feedTag.Next() {
-							// var i = 0
-							// for numFollowed < followCount || numLiked < likeCount || numCommented < commentCount {
-							if !followIsStarted.IsSet() {
-								stopChan <- true
-								return
-							}
+		session.Remaining = tags[i:]
+		session.Current = current
+		checkpoint(db, session)
 
-							log.Println("Fetching the list of images for #" + tag)
-							// i++
-
-							// Getting all the pictures we can on the first page
-							// Instagram will return a 500 sometimes, so we will retry 10 times.
-							// Check retry() for more info.
-
-							// tagFeed[tag] = append(tagFeed[tag], item)
-							// }
-							// var images, ok = tagFeed[tag]
-							// if ok {
-							// 	// log.Println("from cache #" + tag)
-							// } else {
-							// 	err := retry(10, 20*time.Second, func() (err error) {
-							// 		images, err = insta.TagFeed(tag)
-							// 		if err == nil {
-							// 			tagFeed[tag] = images
-							// 		}
-							// 		return
-							// 	})
-							// 	check(err)
-							// }
-
-							var l = 1
-							for _, item := range feedTag.Images {
-								// item.Next()
-								// for index := range images.FeedsResponse.Items {
-								if !followIsStarted.IsSet() {
-									stopChan <- true
-									return
-								}
-								// Exiting the loop if there is nothing left to do
-								if numFollowed >= followCount && numLiked >= likeCount && numCommented >= commentCount {
-									break
-								}
+		current++
 
-								// Skip our own images
-								if item.User.Username == instaUsername {
-									continue
-								}
+		l.Lock()
+		state["follow"] = int(current * 100 / allCount)
+		state["follow_current"] = current
+		state["follow_all_count"] = allCount
+		l.Unlock()
 
-								// Check if we should fetch new images for tag
-								if l >= followCount && l >= likeCount && l >= commentCount {
-									break
-								}
+		// the settings are read for every tag, so /settag applies to the next tag
+		settings, err := getTagSettings(db)
+		if err != nil {
+			return err
+		}
+		limits := settings[tag].limits()
 
-								if stringInStringSlice(item.User.Username, whiteList) {
-									log.Printf("Skip following %s, in white list\n", item.User.Username)
-									continue
-								}
+		reportAsString = fmt.Sprintf("[%d/%d] %d%%", current, allCount, current*100/allCount)
+		if current > 1 {
+			elapsed := time.Since(followStartedAt)
+			perOne := elapsed.Seconds() / float64(current)
+			duration := time.Duration(time.Duration(perOne*float64(allCount-current+1)) * time.Second)
+			reportAsString += fmt.Sprintf(" ~%s", duration.Round(time.Second))
+		}
 
-								// Getting the user info
-								// Instagram will return a 500 sometimes, so we will retry 10 times.
-								// Check retry() for more info.
-								var posterInfo, ok = usersInfo[item.User.Username]
-								if ok {
-									// log.Println("from cache " + posterInfo.User.Username + " - for #" + tag)
-								} else {
-									err := retry(10, 20*time.Second, func() (err error) {
-										posterNew, err := insta.Profiles.ByName(item.User.Username)
-										if err == nil {
-											usersInfo[item.User.Username] = *posterNew
-											posterInfo = *posterNew
-										}
-										return
-									})
-									check(err)
-								}
+		for tagItem := range report {
+			if tagItem != tag {
+				if report[tagItem]["like"] > 0 || report[tagItem]["follow"] > 0 || report[tagItem]["comment"] > 0 {
+					reportAsString += fmt.Sprintf("\n#%s: %d 🐾, %d 👍, %d 💌", tagItem, report[tagItem]["follow"], report[tagItem]["like"], report[tagItem]["comment"])
+				} else {
+					reportAsString += fmt.Sprintf("\n#%s: no actions, possibly not enough images", tagItem)
+				}
+			}
+		}
 
-								poster := posterInfo
-								followerCount := poster.FollowerCount
-								likesCount := item.Likes
-								commentsCount := item.CommentCount
-								followingCount := poster.FollowingCount
+		reportAsString += fmt.Sprintf("\n#%s: ...", tag)
 
-								// Will only follow and comment if we like the picture
-								like := numLiked < likeCount && !item.HasLiked
-								follow := numFollowed < followCount && like
-								comment := numCommented < commentCount && like
+		telegramResp <- telegramResponse{reportAsString, "follow"}
 
-								var relationshipRatio float64 // = 0.0
+		feedTag, err := ig.TagFeed(tag)
+		if err != nil {
+			if err == context.Canceled {
+				return err
+			}
+			tlog.WithField("tag", tag).WithError(err).Error("can't fetch tag feed")
+			continue
+		}
 
-								if followerCount != 0 && followingCount != 0 {
-									relationshipRatio = float64(followingCount) / float64(followerCount)
-								}
+		tlog := tlog.WithField("tag", tag)
+		tlog.Info("fetching the list of images")
 
-								// log.Println("Checking followers for " + poster.Username + " - for #" + tag)
+		// acted counts the images of the tag which were acted on
+		var acted = 1
+		for _, item := range feedTag {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Exiting the loop if there is nothing left to do
+			if numFollowed >= limits.FollowCount && numLiked >= limits.Like.Count && numCommented >= limits.Comment.Count {
+				break
+			}
 
-								if follow {
-									if relationshipRatio == 0 || relationshipRatio < potencyRatio {
-										log.Printf("%s is not a potential user with the relationship ratio of %.2f (%d/%d) ~skipping user\n", poster.Username, relationshipRatio, followingCount, followerCount)
-										follow = false
-									} else {
-										log.Printf("%s with the relationship ratio of %.2f (%d/%d)\n", poster.Username, relationshipRatio, followingCount, followerCount)
-									}
-								}
+			// Skip our own images
+			if item.User.Username == instaUsername {
+				continue
+			}
 
-								// if followerCount > followUpperLimit {
-								// 	log.Printf("%s has %d followers, more than max %d\n", poster.Username, followerCount, followUpperLimit)
-								// 	follow = false
-								// } else if followerCount < followLowerLimit {
-								// 	log.Printf("%s has %d followers, less than min %d\n", poster.Username, followerCount, followLowerLimit)
-								// 	follow = false
-								// }
-
-								if likesCount > likeUpperLimit {
-									log.Printf("%s's image has %d likes, more than max %d\n", poster.Username, likesCount, likeUpperLimit)
-									like = false
-								} else if likesCount < likeLowerLimit {
-									log.Printf("%s's image has %d likes, less than min %d\n", poster.Username, likesCount, likeLowerLimit)
-									like = false
-								}
+			// Check if we should fetch new images for tag
+			if acted >= limits.FollowCount && acted >= limits.Like.Count && acted >= limits.Comment.Count {
+				break
+			}
 
-								if commentsCount > commentUpperLimit {
-									log.Printf("%s's image has %d comments, more than max %d\n", poster.Username, commentsCount, commentUpperLimit)
-									comment = false
-								} else if commentsCount < commentLowerLimit {
-									log.Printf("%s's image has %d comments, less than min %d\n", poster.Username, commentsCount, commentLowerLimit)
-									comment = false
-								}
+			// Getting the user info
+			// Instagram will return a 500 sometimes, so we will retry 10 times.
+			// Check retry() for more info.
+			var posterInfo, ok = usersInfo[item.User.Username]
+			if !ok {
+				err := retry(10, 20*time.Second, func() (err error) {
+					posterNew, err := ig.Profile(item.User.Username)
+					if err == nil {
+						usersInfo[item.User.Username] = *posterNew
+						posterInfo = *posterNew
+					}
+					if err == context.Canceled {
+						return nil
+					}
+					return
+				})
+				check(err)
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
 
-								if like || comment || follow {
-									// log.Printf("%s has %d followers\n", poster.Username, followerCount)
-
-									if relationshipRatio >= potencyRatio {
-										l++
-										// Like, then comment/follow
-										if like {
-											if userLikesCount, ok := likesToAccountPerSession[posterInfo.Username]; ok {
-												if userLikesCount < maxLikesToAccountPerSession {
-													likeImage(tag, db, item, posterInfo)
-													item.HasLiked = true
-												} else {
-													log.Println("Likes count per user reached [" + poster.Username + "]")
-												}
-											} else {
-												likeImage(tag, db, item, posterInfo)
-											}
-
-											previoslyFollowed, _ := getFollowed(db, posterInfo.Username)
-											if previoslyFollowed != "" {
-												log.Printf("%s already following (%s), skipping\n", posterInfo.Username, previoslyFollowed)
-											} else {
-												if comment {
-													if !item.HasLiked {
-														commentImage(tag, db, item)
-													}
-												}
-												if follow {
-													followUser(tag, db, posterInfo)
-												}
-											}
-										}
-									}
-								} else {
-									log.Printf("%s, nothing to do\n", poster.Username)
-								}
+			poster := posterInfo
 
-								reportAsString = fmt.Sprintf("[%d/%d] %d%%", state["follow_current"], state["follow_all_count"], state["follow"])
-								for tag := range report {
-									if report[tag]["like"] > 0 || report[tag]["follow"] > 0 || report[tag]["comment"] > 0 {
-										reportAsString += fmt.Sprintf("\n#%s: %d 🐾, %d 👍, %d 💌", tag, report[tag]["follow"], report[tag]["like"], report[tag]["comment"])
-									} else {
-										reportAsString += fmt.Sprintf("\n#%s: ...", tag)
-									}
-								}
+			action, reason := filterCandidate(db, &poster, &item)
+			if action == filterSkip {
+				tlog.WithFields(logrus.Fields{"username": poster.Username, "reason": reason}).Debug("filtered out, skipping")
+				continue
+			}
+
+			// Will only follow and comment if we like the picture
+			like := numLiked < limits.Like.Count && !item.HasLiked
+			follow := numFollowed < limits.FollowCount && like && action != filterLikeOnly && !followBudgetUsedUp
+			comment := numCommented < limits.Comment.Count && like && action != filterLikeOnly
+			if action == filterLikeOnly {
+				tlog.WithFields(logrus.Fields{"username": poster.Username, "reason": reason}).Debug("filtered to like only")
+			}
 
-								telegramResp <- telegramResponse{reportAsString, "follow"}
+			// Without a filters section the thresholds of the tag apply, otherwise the rules have decided
+			potential := true
+			if !filtersConfigured {
+				followerCount := poster.FollowerCount
+				likesCount := item.Likes
+				commentsCount := item.CommentCount
+				followingCount := poster.FollowingCount
 
-								// This is to avoid the temporary ban by Instagram
-								time.Sleep(17 * time.Second)
+				var relationshipRatio float64 // = 0.0
+
+				if followerCount != 0 && followingCount != 0 {
+					relationshipRatio = float64(followingCount) / float64(followerCount)
+				}
+				potential = relationshipRatio >= limits.PotencyRatio
+
+				if follow {
+					if relationshipRatio == 0 || relationshipRatio < limits.PotencyRatio {
+						tlog.WithFields(logrus.Fields{"username": poster.Username, "ratio": relationshipRatio, "following": followingCount, "followers": followerCount}).Debug("not a potential user, skipping follow")
+						follow = false
+					} else {
+						tlog.WithFields(logrus.Fields{"username": poster.Username, "ratio": relationshipRatio, "following": followingCount, "followers": followerCount}).Debug("potential user")
+					}
+				}
+
+				if likesCount > limits.Like.Max {
+					tlog.WithFields(logrus.Fields{"username": poster.Username, "likes": likesCount, "max": limits.Like.Max}).Debug("too many likes, skipping like")
+					like = false
+				} else if likesCount < limits.Like.Min {
+					tlog.WithFields(logrus.Fields{"username": poster.Username, "likes": likesCount, "min": limits.Like.Min}).Debug("too few likes, skipping like")
+					like = false
+				}
+
+				if commentsCount > limits.Comment.Max {
+					tlog.WithFields(logrus.Fields{"username": poster.Username, "comments": commentsCount, "max": limits.Comment.Max}).Debug("too many comments, skipping comment")
+					comment = false
+				} else if commentsCount < limits.Comment.Min {
+					tlog.WithFields(logrus.Fields{"username": poster.Username, "comments": commentsCount, "min": limits.Comment.Min}).Debug("too few comments, skipping comment")
+					comment = false
+				}
+			}
 
-								feedTag.Next()
+			if like || comment || follow {
+				if potential {
+					acted++
+					// Like, then comment/follow
+					if like {
+						if userLikesCount, ok := likesToAccountPerSession[posterInfo.Username]; ok {
+							if userLikesCount < maxLikesToAccountPerSession {
+								if err := likeImage(ctx, ig, tag, db, item, posterInfo); err != nil {
+									return err
+								}
+								item.HasLiked = true
+							} else {
+								tlog.WithField("username", poster.Username).Debug("likes count per user reached")
 							}
-							// log.Printf("%s done\n\n", poster.Username)
-							// }
-
-							// if viper.IsSet("limits.max_retry") && i > viper.GetInt("limits.max_retry") {
-							// 	log.Println("Currently not enough images for this tag to achieve goals")
-							// 	break
-							// }
-							// }
-							// }
-							// }
-
-							// reportAsString = fmt.Sprintf("[%d/%d] %d%%", state["follow_current"], state["follow_all_count"], state["follow"])
-							// for tag := range report {
-							// 	if report[tag]["like"] > 0 || report[tag]["follow"] > 0 || report[tag]["comment"] > 0 {
-							// 		reportAsString += fmt.Sprintf("\n#%s: %d 🐾, %d 👍, %d 💌", tag, report[tag]["follow"], report[tag]["like"], report[tag]["comment"])
-							// 	} else {
-							// 		reportAsString += fmt.Sprintf("\n#%s: no actions, possibly not enough images", tag)
-							// 	}
-							// }
-
-							if current != allCount {
-								reportAsString += fmt.Sprintf("\n... sleep %d seconds", 10)
+						} else {
+							if err := likeImage(ctx, ig, tag, db, item, posterInfo); err != nil {
+								return err
 							}
+						}
 
-							telegramResp <- telegramResponse{reportAsString, "follow"}
-
-							if current != allCount {
-								time.Sleep(10 * time.Second)
+						previoslyFollowed, _ := getFollowed(db, posterInfo.Username)
+						if previoslyFollowed != "" {
+							tlog.WithFields(logrus.Fields{"username": posterInfo.Username, "followed": previoslyFollowed}).Debug("already following, skipping")
+						} else {
+							if comment {
+								if !item.HasLiked {
+									commentImage(ig, tag, db, item)
+								}
+							}
+							if follow {
+								if err := followUser(ctx, ig, tag, db, posterInfo); err != nil {
+									if _, ok := err.(*budgetError); !ok {
+										return err
+									}
+									followBudgetUsedUp = true
+									tlog.WithError(err).Info("only liking")
+								}
 							}
 						}
 					}
 				}
+			} else {
+				tlog.WithField("username", poster.Username).Debug("nothing to do")
+			}
 
-				stopChan <- true
-			}()
-		case <-stopChan:
+			reportAsString = fmt.Sprintf("[%d/%d] %d%%", current, allCount, current*100/allCount)
+			for tag := range report {
+				if report[tag]["like"] > 0 || report[tag]["follow"] > 0 || report[tag]["comment"] > 0 {
+					reportAsString += fmt.Sprintf("\n#%s: %d 🐾, %d 👍, %d 💌", tag, report[tag]["follow"], report[tag]["like"], report[tag]["comment"])
+				} else {
+					reportAsString += fmt.Sprintf("\n#%s: ...", tag)
+				}
+			}
 
-			l.RLock()
-			elapsed := time.Since(followStartedAt)
-			l.RUnlock()
+			telegramResp <- telegramResponse{reportAsString, "follow"}
+			checkpoint(db, session)
 
-			if reportAsString != "" {
-				reportAsString += fmt.Sprintf("\n\nFollowing is finished by %s", elapsed.Round(time.Second))
-			} else {
-				reportAsString = "Follow finished"
+			// This is to avoid the temporary ban by Instagram
+			if err := takeBudget(ctx, db, "browse"); err != nil {
+				return err
 			}
+		}
 
-			telegramResp <- telegramResponse{reportAsString, "follow"}
+		if current != allCount {
+			reportAsString += "\n... next tag"
+		}
 
-			l.Lock()
-			state["follow"] = -1
-			reportAsString = ""
-			l.Unlock()
+		telegramResp <- telegramResponse{reportAsString, "follow"}
 
-			return
+		if current != allCount {
+			if err := takeBudget(ctx, db, "tag"); err != nil {
+				return err
+			}
 		}
 	}
+
+	return nil
 }
 
 // // Browses the page for a certain tag, until we reach the limits
@@ -1059,33 +940,46 @@ func loopTags(db *bolt.DB, innerChan chan string, stopChan chan bool) {
 
 // }
 
-// Likes an image, if not liked already
-func likeImage(tag string, db *bolt.DB, image goinsta.Item, userInfo goinsta.User) {
-	log.Println("Liking the picture https://www.instagram.com/p/" + image.Code)
+// Likes an image, if not liked already. Only budget and cancellation errors are returned.
+func likeImage(ctx context.Context, ig InstagramClient, tag string, db *bolt.DB, image goinsta.Item, userInfo goinsta.User) error {
+	tlog := taskLogger("follow").WithFields(logrus.Fields{"tag": tag, "username": userInfo.Username, "action": "like"})
 
 	if !image.HasLiked {
+		if err := takeBudget(ctx, db, "like"); err != nil {
+			return err
+		}
+		tlog.WithField("post", image.Code).Info("liking")
+
+		var err error
 		if !*dev {
-			image.Like()
-			// insta.Like(image.ID)
+			err = ig.Like(&image)
+			if err != nil {
+				refundBudget(db, "like")
+				tlog.WithError(err).Error("like failed")
+			}
+		}
+		audit(db, "like", image.Code, "follow", "#"+tag, err)
+		if err != nil {
+			return nil
 		}
-		// log.Println("Liked")
+
 		numLiked++
 
 		report[tag]["like"]++
-		incStats(db, "like")
+		incStats(db, "like", "tag")
 		likesToAccountPerSession[userInfo.Username]++
-	} else {
-		// log.Println("Image already liked")
 	}
+	return nil
 }
 
 // Comments an image
-func commentImage(tag string, db *bolt.DB, image goinsta.Item) {
+func commentImage(ig InstagramClient, tag string, db *bolt.DB, image goinsta.Item) {
 	// FIXME
 	return
 
 	// // rand.Seed(time.Now().Unix())
-	// text := commentsList[rand.Intn(len(commentsList))]
+	// comments, _ := getList(db, "comments")
+	// text := comments[rand.Intn(len(comments))]
 	// if !*dev {
 	// 	// image.Comments.Sync()
 	// 	err := image.Comments.Add(text)
@@ -1099,217 +993,101 @@ func commentImage(tag string, db *bolt.DB, image goinsta.Item) {
 	// numCommented++
 
 	// report[tag]["comment"]++
-	// incStats(db, "comment")
+	// incStats(db, "comment", "tag")
 }
 
-// Follows a user, if not following already
-func followUser(tag string, db *bolt.DB, user goinsta.User) {
+// Follows a user, if not following already. Only budget and cancellation errors are returned.
+func followUser(ctx context.Context, ig InstagramClient, tag string, db *bolt.DB, user goinsta.User) error {
 	// user := userInfo.User
 	// userFriendShip := user.Friendship
 	// check(err)
 	// If not following already
 	if !user.Friendship.Following {
+		if err := takeBudget(ctx, db, "follow"); err != nil {
+			return err
+		}
 		if !*dev {
-			if user.IsPrivate {
-				log.Printf("%s is private, skipping follow\n", user.Username)
+			taskLogger("follow").WithFields(logrus.Fields{"tag": tag, "username": user.Username, "action": "follow"}).Info("following")
+			err := ig.Follow(&user)
+			audit(db, "follow", user.Username, "follow", "#"+tag, err)
+			if err != nil {
+				refundBudget(db, "follow")
+				taskLogger("follow").WithFields(logrus.Fields{"tag": tag, "username": user.Username, "action": "follow"}).WithError(err).Error("follow failed")
 			} else {
-				log.Printf("Following %s\n", user.Username)
-				err := user.Follow()
-				if err != nil {
-					log.Println(err)
-				} else {
-					user.Friendship.Following = true
+				user.Friendship.Following = true
 
-					// if !userFriendShip.Following {
-					// 	log.Println("Not followed")
-					// }
-				}
+				// if !userFriendShip.Following {
+				// 	log.Println("Not followed")
+				// }
 			}
+		} else {
+			audit(db, "follow", user.Username, "follow", "#"+tag, nil)
 		}
 		// log.Println("Followed")
 		if user.Friendship.Following {
 			numFollowed++
 			report[tag]["follow"]++
-			incStats(db, "follow")
-			setFollowed(db, user.Username)
+			incStats(db, "follow", "tag")
+			setFollowed(db, user.Username, "tag", tag, false)
 		}
 	} else {
-		log.Println("Already following " + user.Username)
+		taskLogger("follow").WithFields(logrus.Fields{"tag": tag, "username": user.Username}).Debug("already following")
 	}
+	return nil
 }
 
-func startFollow(bot *tgbotapi.BotAPI, startChan chan bool, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	if followIsStarted.IsSet() {
-		msg.Text = fmt.Sprintf("Follow in progress (%d%%)", state["follow"])
-
-		l.RLock()
-		rn := editMessage["follow"]
-		ln := len(rn)
-		l.RUnlock()
-
-		if ln > 0 && intInStringSlice(int(userID), getKeys(rn)) {
-			for UserID, EditID := range rn {
-				edit := tgbotapi.EditMessageTextConfig{
-					BaseEdit: tgbotapi.BaseEdit{
-						ChatID:    int64(UserID),
-						MessageID: EditID,
-					},
-					Text: msg.Text,
-				}
-				bot.Send(edit)
-			}
-		} else {
-			msgRes, err := bot.Send(msg)
-			if err == nil {
-				l.Lock()
-				editMessage["follow"][int(userID)] = msgRes.MessageID
-				l.Unlock()
-			}
-		}
-	} else {
-		l.Lock()
-		editMessage["follow"] = make(map[int]int)
-		l.Unlock()
-
-		startChan <- true
-
-		msg.Text = "Starting follow"
-		msgRes, err := bot.Send(msg)
-		if err == nil {
-			l.Lock()
-			editMessage["follow"][int(userID)] = msgRes.MessageID
-			l.Unlock()
-		}
-	}
+// startTask starts the task and remembers the reply, so progress reports will edit it.
+// If the task is already running, the current progress is shown instead.
+func startTask(bot Messenger, runner *taskRunner, name, arg string, userID int64) {
+	reportTaskStart(bot, name, runner.Start(name, arg), userID)
 }
 
-func startUnfollow(bot *tgbotapi.BotAPI, startChan chan bool, userID int64) {
+// reportTaskStart replies with the result of starting the task
+func reportTaskStart(bot Messenger, name string, err error, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
-	if unfollowIsStarted.IsSet() {
-		msg.Text = fmt.Sprintf("Unfollow in progress (%d%%)", state["unfollow"])
 
+	if err == errTaskRunning {
 		l.RLock()
-		rn := editMessage["unfollow"]
-		ln := len(rn)
+		msg.Text = fmt.Sprintf("%s in progress (%d%%)", name, state[name])
+		editID, ok := editMessage[name][int(userID)]
 		l.RUnlock()
 
-		if ln > 0 && intInStringSlice(int(userID), getKeys(rn)) {
-			for UserID, EditID := range rn {
-				edit := tgbotapi.EditMessageTextConfig{
-					BaseEdit: tgbotapi.BaseEdit{
-						ChatID:    int64(UserID),
-						MessageID: EditID,
-					},
-					Text: msg.Text,
-				}
-				bot.Send(edit)
-			}
-		} else {
-			msgRes, err := bot.Send(msg)
-			if err == nil {
-				// log.Print(msgRes, msgRes.MessageID)
-				l.Lock()
-				editMessage["unfollow"][int(userID)] = msgRes.MessageID
-				l.Unlock()
-			}
+		if ok {
+			bot.Edit(userID, editID, msg.Text)
+			return
 		}
+	} else if err != nil {
+		msg.Text = fmt.Sprintf("can't start %s: %s", name, err)
+		bot.Send(msg)
+		return
 	} else {
 		l.Lock()
-		editMessage["unfollow"] = make(map[int]int)
+		editMessage[name] = make(map[int]int)
 		l.Unlock()
 
-		startChan <- true
-
-		msg.Text = "Starting unfollow"
-		fmt.Println(msg.Text)
-		msgRes, err := bot.Send(msg)
-		if err == nil {
-			l.Lock()
-			editMessage["unfollow"][int(userID)] = msgRes.MessageID
-			l.Unlock()
-		}
+		msg.Text = "Starting " + name
 	}
-}
-
-func startRefollow(bot *tgbotapi.BotAPI, startChan chan bool, innerRefollowChan chan string, userID int64, target string) {
-	msg := tgbotapi.NewMessage(userID, "")
-	if refollowIsStarted.IsSet() {
-		msg.Text = fmt.Sprintf("Refollow in progress (%d%%)", state["refollow"])
 
-		l.RLock()
-		rn := editMessage["refollow"]
-		ln := len(rn)
-		l.RUnlock()
-
-		if ln > 0 && intInStringSlice(int(userID), getKeys(rn)) {
-			edit := tgbotapi.EditMessageTextConfig{
-				BaseEdit: tgbotapi.BaseEdit{
-					ChatID:    int64(userID),
-					MessageID: rn[int(userID)],
-				},
-				Text: msg.Text,
-			}
-			bot.Send(edit)
-		} else {
-			msgRes, err := bot.Send(msg)
-			if err == nil {
-				l.Lock()
-				editMessage["refollow"][int(userID)] = msgRes.MessageID
-				l.Unlock()
-			}
-		}
-	} else {
-		startChan <- true
-		msg.Text = "Starting refollow"
-		msgRes, err := bot.Send(msg)
-		if err == nil {
-			l.Lock()
-			editMessage["refollow"][int(userID)] = msgRes.MessageID
-			l.Unlock()
+	msgRes, err := bot.Send(msg)
+	if err == nil {
+		l.Lock()
+		if editMessage[name] == nil {
+			editMessage[name] = make(map[int]int)
 		}
-		innerRefollowChan <- target
+		editMessage[name][int(userID)] = msgRes.MessageID
+		l.Unlock()
 	}
 }
 
-func startFollowLikers(bot *tgbotapi.BotAPI, startChan chan bool, innerFollowLikersChan chan string, userID int64, target string) {
+// cancelTask stops the task if it is running
+func cancelTask(bot Messenger, runner *taskRunner, name string, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
-	if followLikersIsStarted.IsSet() {
-		msg.Text = fmt.Sprintf("followLikers in progress (%d%%)", state["followLikers"])
-
-		l.RLock()
-		rn := editMessage["followLikers"]
-		ln := len(rn)
-		l.RUnlock()
-
-		if ln > 0 && intInStringSlice(int(userID), getKeys(rn)) {
-			edit := tgbotapi.EditMessageTextConfig{
-				BaseEdit: tgbotapi.BaseEdit{
-					ChatID:    int64(userID),
-					MessageID: rn[int(userID)],
-				},
-				Text: msg.Text,
-			}
-			bot.Send(edit)
-		} else {
-			msgRes, err := bot.Send(msg)
-			if err == nil {
-				l.Lock()
-				editMessage["followLikers"][int(userID)] = msgRes.MessageID
-				l.Unlock()
-			}
-		}
+	if runner.Cancel(name) {
+		msg.Text = fmt.Sprintf("Cancelling %s", name)
 	} else {
-		startChan <- true
-		msg.Text = "Starting followLikers"
-		msgRes, err := bot.Send(msg)
-		if err == nil {
-			l.Lock()
-			editMessage["followLikers"][int(userID)] = msgRes.MessageID
-			l.Unlock()
-		}
-		innerFollowLikersChan <- target
+		msg.Text = fmt.Sprintf("%s is not running", name)
 	}
+	bot.Send(msg)
 }
 
 func getJobState(c *cron.Cron, id int) (result string) {
@@ -1328,271 +1106,178 @@ func getJobState(c *cron.Cron, id int) (result string) {
 	return "unknown"
 }
 
-func sendStats(bot *tgbotapi.BotAPI, db *bolt.DB, c *cron.Cron, userID int64) {
-	message := `<i>%s</i>
-
-<b>Today stats</b>
-Unfollowed — %d
-Followed — %d
-	Refollowed — %d
-	Followed likers — %d
-Liked — %d
-Commented — %d`
-
-	unfollowCount, _ := getStats(db, "unfollow")
-	followCount, _ := getStats(db, "follow")
-	refollowCount, _ := getStats(db, "refollow")
-	followLikersCount, _ := getStats(db, "followLikers")
-	likeCount, _ := getStats(db, "like")
-	commentCount, _ := getStats(db, "comment")
-
-	stats := getStatus()
-
-	msg := tgbotapi.NewMessage(userID, fmt.Sprintf(message,
-		stats,
-		unfollowCount,
-		followCount,
-		refollowCount,
-		followLikersCount,
-		likeCount,
-		commentCount,
-		// getJobState(c, cronFollow),
-		// getJobState(c, cronUnfollow),
-		// getJobState(c, cronStats),
-		// getJobState(c, cronLike),
-	))
-
-	msg.DisableWebPagePreview = true
-	msg.ParseMode = "HTML"
-	msg.DisableNotification = true
+func sendStats(bot Messenger, db *bolt.DB, c *cron.Cron, args string, userID int64) {
+	var texts []string
+	if strings.TrimSpace(args) == "" {
+		texts = []string{todayStats(db)}
+	} else {
+		from, to, err := parseStatsRange(args, time.Now())
+		if err != nil {
+			texts = []string{fmt.Sprintf("%s\n/stats 7d | month | 2006-01-02 | 2006-01-02..2006-01-31", err)}
+		} else if days, err := getStatsRange(db, from, to); err != nil {
+			texts = []string{err.Error()}
+		} else {
+			texts = formatStatsRange(days)
+		}
+	}
 
+	chatIDs := []int64{userID}
 	if userID == -1 {
+		chatIDs = nil
 		for _, id := range admins {
-			userID, _ = strconv.ParseInt(id, 10, 64)
-			msg.ChatID = userID
-			bot.Send(msg)
+			chatID, _ := strconv.ParseInt(id, 10, 64)
+			chatIDs = append(chatIDs, chatID)
 		}
-	} else {
-		bot.Send(msg)
-	}
-}
-
-func sendComments(bot *tgbotapi.BotAPI, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	if len(commentsList) > 0 {
-		msg.Text = strings.Join(commentsList, ", ")
-	} else {
-		msg.Text = "Comments is empty"
 	}
 
-	bot.Send(msg)
-}
-
-func addComments(bot *tgbotapi.BotAPI, comments string, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	if len(comments) > 0 {
-		newComments := strings.Split(comments, ", ")
-		newComments = append(commentsList, newComments...)
-		newComments = sliceUnique(newComments)
-		viper.Set("comments", newComments)
-		viper.WriteConfig()
-		msg.Text = "Comments updated"
-	} else {
-		msg.Text = "Comments is empty"
+	for _, chatID := range chatIDs {
+		for _, text := range texts {
+			msg := tgbotapi.NewMessage(chatID, text)
+			msg.DisableWebPagePreview = true
+			msg.ParseMode = "HTML"
+			msg.DisableNotification = true
+			if _, err := bot.Send(msg); err != nil {
+				logger.WithField("chat", chatID).WithError(err).Error("can't send stats")
+				break
+			}
+		}
 	}
-
-	bot.Send(msg)
 }
 
-func removeComments(bot *tgbotapi.BotAPI, comments string, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	if len(comments) > 0 {
-		removeComments := strings.Split(comments, ", ")
-		var newComments []string
-		for _, comment := range commentsList {
-			if stringInStringSlice(comment, removeComments) {
+// limitNames are the limits which can be changed by /updatelimits
+var limitNames = []string{"max_unfollow_per_day", "days_before_unfollow", "max_likes_to_account_per_session", "max_retry", "like.min", "like.count", "like.max", "follow.count", "follow.potency_ratio", "comment.min", "comment.count", "comment.max"}
 
-			} else {
-				newComments = append(newComments, comment)
-			}
+// getLimitValues returns the current limits by name
+func getLimitValues() map[string]interface{} {
+	values := make(map[string]interface{}, len(limitNames))
+	for _, limit := range limitNames {
+		if limit == "follow.potency_ratio" {
+			values[limit] = viper.GetFloat64("limits." + limit)
+		} else {
+			values[limit] = viper.GetInt("limits." + limit)
 		}
-		newComments = sliceUnique(newComments)
-		viper.Set("comments", newComments)
-		viper.WriteConfig()
-		msg.Text = "Comments removed"
-	} else {
-		msg.Text = "Comments is empty"
 	}
-
-	bot.Send(msg)
+	return values
 }
 
-func sendTags(bot *tgbotapi.BotAPI, userID int64) {
+func getLimits(bot Messenger, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
-	if len(tagsList) > 0 {
-		// keys := GetKeys(tagsList)
-		// msg.Text = strings.Join(keys, ", ")
-		msg.Text = strings.Join(tagsList, ", ")
-	} else {
-		msg.Text = "Tags is empty"
-	}
-
-	bot.Send(msg)
-}
 
-func addTags(bot *tgbotapi.BotAPI, tag string, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	// if len(tags) > 0 {
-	tag = strings.Replace(tag, ".", "", -1)
-	if len(tag) > 0 {
-		newTags := strings.Split(tag, ", ")
-		newTags = append(tagsList, newTags...)
-		newTags = sliceUnique(newTags)
-		viper.Set("tags", newTags)
-		viper.WriteConfig()
-		msg.Text = "Tags added"
-	} else {
-		msg.Text = "Tag is empty"
+	values := getLimitValues()
+	for _, limit := range limitNames {
+		if limit == "follow.potency_ratio" {
+			msg.Text += fmt.Sprintf("%s: %.2f\n", limit, values[limit])
+		} else {
+			msg.Text += fmt.Sprintf("%s: %d\n", limit, values[limit])
+		}
 	}
 
 	bot.Send(msg)
 }
 
-func removeTags(bot *tgbotapi.BotAPI, tags string, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	if len(tags) > 0 {
-		removeTags := strings.Split(tags, ", ")
-		var newTags []string
-		for _, tag := range tagsList {
-			if stringInStringSlice(tag, removeTags) {
+// setLimits checks the values of limits from limitNames and saves them to the config, nothing is changed if one of them is invalid
+func setLimits(values map[string]string) error {
+	parsed := make(map[string]interface{}, len(values))
+	for limit, count := range values {
+		if !stringInStringSlice(limit, limitNames) {
+			return fmt.Errorf("limitname maybe one of: %s", strings.Join(limitNames, ", "))
+		}
 
-			} else {
-				newTags = append(newTags, tag)
+		if limit == "follow.potency_ratio" {
+			limitCount, _ := strconv.ParseFloat(count, 64)
+			if limitCount < -100 || limitCount > 100 {
+				return fmt.Errorf("%s: count should be equal or greater than -100 and less or equal than 100", limit)
+			}
+			parsed[limit] = limitCount
+		} else {
+			limitCount, _ := strconv.Atoi(count)
+			if limitCount < 0 || limitCount > 10000 {
+				return fmt.Errorf("%s: count should be equal or greater than 0 and less or equal than 10000", limit)
 			}
+			parsed[limit] = limitCount
 		}
-		newTags = sliceUnique(newTags)
-		viper.Set("tags", newTags)
-		viper.WriteConfig()
-		msg.Text = "Tags removed"
-	} else {
-		msg.Text = "Tags is empty"
-	}
-
-	bot.Send(msg)
-}
-
-func sendWhitelist(bot *tgbotapi.BotAPI, UserID int64) {
-	msg := tgbotapi.NewMessage(UserID, "")
-	if len(whiteList) > 0 {
-		// keys := GetKeys(tagsList)
-		// msg.Text = strings.Join(keys, ", ")
-		msg.Text = strings.Join(whiteList, ", ")
-	} else {
-		msg.Text = "whitelist is empty"
 	}
 
-	bot.Send(msg)
-}
-
-func addWhitelist(bot *tgbotapi.BotAPI, item string, UserID int64) {
-	msg := tgbotapi.NewMessage(UserID, "")
-	// if len(tags) > 0 {
-	item = strings.Replace(item, ".", "", -1)
-	if len(item) > 0 {
-		newWhiteList := strings.Split(item, ", ")
-		newWhiteList = append(whiteList, newWhiteList...)
-		newWhiteList = sliceUnique(newWhiteList)
-		viper.Set("whitelist", newWhiteList)
-		viper.WriteConfig()
-		msg.Text = "whiteList added"
-	} else {
-		msg.Text = "Item is empty"
+	changed := make(map[string]interface{}, len(parsed))
+	for limit, value := range parsed {
+		changed["limits."+limit] = value
 	}
-
-	bot.Send(msg)
+	return changeConfig(changed)
 }
 
-func removeWhitelist(bot *tgbotapi.BotAPI, items string, UserID int64) {
-	msg := tgbotapi.NewMessage(UserID, "")
-	if len(items) > 0 {
-		removeWhiteList := strings.Split(items, ", ")
-		var newWhiteList []string
-		for _, item := range whiteList {
-			if stringInStringSlice(item, removeWhiteList) {
-
-			} else {
-				newWhiteList = append(newWhiteList, item)
-			}
+func updateLimits(bot Messenger, limitStr string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+	s := strings.Split(limitStr, " ")
+	if len(s) != 2 {
+		msg.Text = "/updatelimits limitname integer\nlimitname maybe one of: " + strings.Join(limitNames, ", ")
+	} else if err := setLimits(map[string]string{s[0]: s[1]}); err != nil {
+		if s[0] == "follow.potency_ratio" {
+			msg.Text = "/updatelimits limitname float\n" + err.Error()
+		} else {
+			msg.Text = "/updatelimits limitname integer\n" + err.Error()
 		}
-		newWhiteList = sliceUnique(newWhiteList)
-		viper.Set("whitelist", newWhiteList)
-		viper.WriteConfig()
-		msg.Text = "whiteList removed"
 	} else {
-		msg.Text = "Item is empty"
+		msg.Text = "Limit updated"
 	}
 
 	bot.Send(msg)
 }
 
-func getLimits(bot *tgbotapi.BotAPI, userID int64) {
+func updateSchedule(bot Messenger, args string, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
+	msg.ParseMode = "HTML"
+	usage := "/schedule list\n/schedule pause job\n/schedule resume job\n/schedule set job spec (6 fields, with seconds)\n/schedule set job args value"
 
-	limits := []string{"max_unfollow_per_day", "days_before_unfollow", "max_likes_to_account_per_session", "max_retry", "like.min", "like.count", "like.max", "follow.count", "follow.potency_ratio", "comment.min", "comment.count", "comment.max"}
-	for _, limit := range limits {
-		if limit == "follow.potency_ratio" {
-			msg.Text += fmt.Sprintf("%s: %.2f\n", limit, viper.GetFloat64("limits."+limit))
-		} else {
-			msg.Text += limit + ": " + strconv.Itoa(viper.GetInt("limits."+limit)) + "\n"
-		}
+	s := strings.Fields(args)
+	if len(s) == 0 {
+		s = []string{"list"}
 	}
 
-	bot.Send(msg)
-}
-
-func updateLimits(bot *tgbotapi.BotAPI, limitStr string, userID int64) {
-	msg := tgbotapi.NewMessage(userID, "")
-	s := strings.Split(limitStr, " ")
-	limits := []string{"max_unfollow_per_day", "days_before_unfollow", "max_likes_to_account_per_session", "max_retry", "like.min", "like.count", "like.max", "follow.count", "follow.potency_ratio", "comment.min", "comment.count", "comment.max"}
-	if len(s) != 2 {
-		msg.Text = "/updatelimits limitname integer\nlimitname maybe one of: " + strings.Join(limits, ", ")
-	} else {
-		limit, count := s[0], s[1]
-
-		if stringInStringSlice(limit, limits) {
-			if limit == "follow.potency_ratio" {
-				limitCount, _ := strconv.ParseFloat(count, 64)
-				if limitCount >= -100 && limitCount <= 100 {
-					viper.Set("limits."+limit, limitCount)
-					viper.WriteConfig()
-					msg.Text = "Limit updated"
-				} else {
-					msg.Text = "/updatelimits limitname float\ncount should be equal or greater than -100 and less or equal than 100"
-				}
-			} else {
-				limitCount, _ := strconv.Atoi(count)
-				if limitCount >= 0 && limitCount <= 10000 {
-					viper.Set("limits."+limit, limitCount)
-					viper.WriteConfig()
-					msg.Text = "Limit updated"
-				} else {
-					msg.Text = "/updatelimits limitname integer\ncount should be equal or greater than 0 and less or equal than 10000"
-				}
-			}
-		} else {
-			msg.Text = "/updatelimits limitname integer\nlimitname maybe one of: " + strings.Join(limits, ", ")
+	var err error
+	switch {
+	case s[0] == "list":
+	case (s[0] == "pause" || s[0] == "resume") && len(s) == 2:
+		enabled := s[0] == "resume"
+		if err = jobScheduler.SetEnabled(s[1], enabled); err == nil {
+			err = setConfig("schedule."+s[1]+".enabled", enabled)
 		}
+	case s[0] == "set" && len(s) > 3 && s[2] == "args":
+		jobArgs := strings.Join(s[3:], " ")
+		if err = jobScheduler.SetArgs(s[1], jobArgs); err == nil {
+			err = setConfig("schedule."+s[1]+".args", jobArgs)
+		}
+	case s[0] == "set" && len(s) > 2:
+		spec := strings.Join(s[2:], " ")
+		if err = jobScheduler.SetSpec(s[1], spec); err == nil {
+			err = setConfig("schedule."+s[1]+".spec", spec)
+		}
+	default:
+		msg.ParseMode = ""
+		msg.Text = usage
+		bot.Send(msg)
+		return
 	}
 
+	if err != nil {
+		msg.ParseMode = ""
+		msg.Text = fmt.Sprintf("%s\n\n%s", err, usage)
+	} else {
+		msg.Text = jobScheduler.List()
+	}
 	bot.Send(msg)
 }
 
-func updateProxy(bot *tgbotapi.BotAPI, proxyStr string, userID int64) {
+func updateProxy(bot Messenger, proxyStr string, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
 
+	if _, ok := secretFromEnv(viper.GetViper(), "user.instagram.proxy"); ok {
+		msg.Text = "proxy is set by " + secretEnv["user.instagram.proxy"] + ", change it there"
+		bot.Send(msg)
+		return
+	}
+
 	if proxyStr == "" {
-		viper.Set("user.instagram.proxy", "")
-		viper.WriteConfig()
+		setConfig("user.instagram.proxy", "")
 	} else {
 		proxyURL, _ := url.Parse(proxyStr)
 
@@ -1613,8 +1298,7 @@ func updateProxy(bot *tgbotapi.BotAPI, proxyStr string, userID int64) {
 			proxyStr = strings.TrimPrefix(proxyStr, "http://")
 			proxyStr = strings.TrimPrefix(proxyStr, "https://")
 
-			viper.Set("user.instagram.proxy", "http://"+proxyStr)
-			viper.WriteConfig()
+			setConfig("user.instagram.proxy", "http://"+proxyStr)
 			msg.Text = "proxy updated, /relogin if needed"
 			bot.Send(msg)
 
@@ -1623,28 +1307,24 @@ func updateProxy(bot *tgbotapi.BotAPI, proxyStr string, userID int64) {
 	}
 }
 
-func getLastLikers() (result []string) {
-	user, err := insta.Profiles.ByName(insta.Account.Username)
+func getLastLikers(ig InstagramClient) (result []string) {
+	user, err := ig.Profile(ig.Username())
 	if err != nil {
-		log.Println(err)
-	}
-
-	latest := make([]goinsta.Item, 0)
-	latestItems := user.Feed()
-	for latestItems.Next() {
-		for _, item := range latestItems.Items {
-			latest = append(latest, item)
-		}
+		taskLogger("unfollow").WithError(err).Error("can't get own profile")
+		return result
 	}
 
-	l := latest
-	if len(l) > 10 {
-		l = latest[0:10] //last 10 posts
+	l, err := ig.UserFeed(user, 10) //last 10 posts
+	if err != nil {
+		taskLogger("unfollow").WithError(err).Error("can't get own posts")
 	}
 	for lindex := range l {
 		if l[lindex].Likes > 0 {
-			l[lindex].SyncLikers()
-			likers := l[lindex].Likers
+			likers, err := ig.Likers(&l[lindex])
+			if err != nil {
+				taskLogger("unfollow").WithField("post", l[lindex].Code).WithError(err).Error("can't get likers")
+				continue
+			}
 			for _, item := range likers {
 				if !stringInStringSlice(item.Username, result) {
 					result = append(result, item.Username)
@@ -1713,7 +1393,7 @@ func likeFollowersPosts(db *bolt.DB) {
 	// // log.Println(usernames)
 }
 
-func addWatching(bot *tgbotapi.BotAPI, db *bolt.DB, userid string, userID int64) {
+func addWatching(bot Messenger, db *bolt.DB, userid string, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
 	if len(userid) > 0 {
 		err := setWatching(db, string(userid))
@@ -1726,25 +1406,29 @@ func addWatching(bot *tgbotapi.BotAPI, db *bolt.DB, userid string, userID int64)
 	}
 }
 
-func watch(bot *tgbotapi.BotAPI, db *bolt.DB, args string, userID int64) {
-	if args != "" {
-		argsArray := strings.Fields(args)
-		switch argsArray[0] {
-		case "list":
-			sendWatching(bot, db, userID)
-		case "add":
-			addWatching(bot, db, argsArray[1], userID)
-		case "del":
-			msg := tgbotapi.NewMessage(userID, "")
-			deleteKeyFromBucket(db, "watching", argsArray[1])
-			// need to add checks
-			msg.Text = "Removed " + argsArray[1] + " from watching list"
-			bot.Send(msg)
-		}
+func watch(bot Messenger, db *bolt.DB, args string, userID int64) {
+	argsArray := strings.Fields(args)
+	if len(argsArray) == 0 {
+		return
+	}
+
+	switch {
+	case argsArray[0] == "list":
+		sendWatching(bot, db, userID)
+	case argsArray[0] == "add" && len(argsArray) > 1:
+		addWatching(bot, db, argsArray[1], userID)
+	case argsArray[0] == "del" && len(argsArray) > 1:
+		msg := tgbotapi.NewMessage(userID, "")
+		deleteKeyFromBucket(db, "watching", argsArray[1])
+		// need to add checks
+		msg.Text = "Removed " + argsArray[1] + " from watching list"
+		bot.Send(msg)
+	default:
+		bot.Send(tgbotapi.NewMessage(userID, "/watch add username | del username | list"))
 	}
 }
 
-func sendWatching(bot *tgbotapi.BotAPI, db *bolt.DB, userID int64) {
+func sendWatching(bot Messenger, db *bolt.DB, userID int64) {
 	msg := tgbotapi.NewMessage(userID, "")
 	watchingList, _ := getWatchingList(db)
 	for _, userid := range watchingList {
@@ -1753,7 +1437,7 @@ func sendWatching(bot *tgbotapi.BotAPI, db *bolt.DB, userID int64) {
 	bot.Send(msg)
 }
 
-func sendQueueSize(bot *tgbotapi.BotAPI, db *bolt.DB, userID int64, bucket string) {
+func sendQueueSize(bot Messenger, db *bolt.DB, userID int64, bucket string) {
 	msg := tgbotapi.NewMessage(userID, "")
 	queuesize := bucketStats(db, bucket).KeyN
 	msg.Text = strconv.Itoa(queuesize)
@@ -1766,143 +1450,168 @@ func PercentageChange(old, new int) (delta float64) {
 	return
 }
 
-func getWatchingUser(db *bolt.DB) (string, error) {
-	var userid string
+// getWatchingUser returns the first watched user checked for the first time or whose followers changed by more than 10%,
+// the new count of followers is saved
+func getWatchingUser(ctx context.Context, db *bolt.DB) (string, error) {
+	if !loggedIn() {
+		return "", errNotLoggedIn
+	}
+	ig := withContext(ctx, currentInstaClient())
+
+	// profiles are fetched outside of the transaction, they may wait for the cool-down
+	var watching [][2]string
 	err := db.View(func(tx *bolt.Tx) error {
 		bk := tx.Bucket([]byte("watching"))
 		if bk == nil {
 			return fmt.Errorf("failed to find bucket")
 		}
-		var oldnumber int
-
-		c := tx.Bucket([]byte("watching")).Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-
-			oldnumber, _ = strconv.Atoi(string(v))
-			if oldnumber == 0 {
-				log.Printf("%s have %d followers, checking", k, oldnumber)
-				userid = string(k)
-				user, _ := insta.GetUserByUsername(string(k))
-				var newnumber = user.User.FollowerCount
-				updateDB(db, []byte("watching"), []byte(userid), []byte(strconv.Itoa(newnumber)))
-				break
-			} else {
-				user, _ := insta.GetUserByUsername(string(k))
+		return bk.ForEach(func(k, v []byte) error {
+			watching = append(watching, [2]string{string(k), string(v)})
+			return nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
 
-				var newnumber = user.User.FollowerCount
-				if PercentageChange(oldnumber, newnumber) > 10 {
-					userid = string(k)
-					updateDB(db, []byte("watching"), []byte(userid), []byte(strconv.Itoa(newnumber)))
-					break
-				}
-			}
+	var userid string
+	for _, entry := range watching {
+		username := entry[0]
+		oldnumber, _ := strconv.Atoi(entry[1])
+		if oldnumber == 0 {
+			logger.WithFields(logrus.Fields{"task": "watch", "username": username}).Info("checking followers")
 		}
-		return nil
-	})
-	fmt.Println(userid)
-	return userid, err
-}
 
-func followQueueManager(db *bolt.DB) (startChan chan bool, outerChan, innerChan chan string, stopChan chan bool) {
-	startChan = make(chan bool)
-	outerChan = make(chan string)
-	innerChan = make(chan string)
-	stopChan = make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-startChan:
-				if !followQueueIsStarted.IsSet() {
-					followQueueIsStarted.Set()
-					go startFollowFromQueue(db, 10)
-				} else {
-					fmt.Println("can't start task, task already running!")
-				}
-			case msg := <-outerChan:
-				fmt.Println("followqueue <- ", msg)
-			}
+		user, err := ig.Profile(username)
+		if err == context.Canceled {
+			return "", err
+		} else if err != nil {
+			logger.WithFields(logrus.Fields{"task": "watch", "username": username}).WithError(err).Error("can't get profile")
+			continue
+		}
 
+		var newnumber = user.FollowerCount
+		if oldnumber == 0 || PercentageChange(oldnumber, newnumber) > 10 {
+			userid = username
+			updateDB(db, []byte("watching"), []byte(userid), []byte(strconv.Itoa(newnumber)))
+			break
 		}
-	}()
-	return startChan, outerChan, innerChan, stopChan
+	}
+	logger.WithFields(logrus.Fields{"task": "watch", "username": userid}).Debug("watching user")
+	return userid, nil
 }
 
-func scrapFollowersFromUser(db *bolt.DB, username string) {
+// scrapFollowersFromUser adds the public followers of username to the follow queue
+func scrapFollowersFromUser(ctx context.Context, db *bolt.DB, username string) error {
+	if !loggedIn() {
+		return errNotLoggedIn
+	}
+	ig := withContext(ctx, currentInstaClient())
 
-	user, err := insta.GetUserByUsername(username)
-	if err != nil {
-		fmt.Println(fmt.Sprintf("%s", err))
-		return
+	user, err := ig.Profile(username)
+	if err == context.Canceled {
+		return err
+	} else if err != nil {
+		taskLogger("scrap").WithField("username", username).WithError(err).Error("can't get profile")
+		return nil
 	}
-	if user.User.IsPrivate {
-		userFriendShip, err := insta.UserFriendShip(user.User.ID)
+	if user.IsPrivate {
+		err := ig.SyncFriendship(user)
+		if err == context.Canceled {
+			return err
+		}
 		check(err)
-		if !userFriendShip.Following {
-			log.Printf("User profile \"%s\" is private and we are not following, can't process", username)
-			return
+		if !user.Friendship.Following {
+			taskLogger("scrap").WithField("username", username).Warn("private and not followed, can't scrap")
+			return nil
 		}
 	}
-	followers, err := insta.TotalUserFollowers(user.User.ID)
+	followers := ig.Followers(user)
+	var users = allUsers(followers)
+	err = followers.Error()
+	if err == context.Canceled {
+		return err
+	}
 	check(err)
-	var users = followers.Users
 	for index := range users {
 		if users[index].IsPrivate {
-			log.Printf("%s is private, skipping\n", users[index].Username)
+			taskLogger("scrap").WithField("username", users[index].Username).Debug("private, skipping")
 		} else {
 			previoslyFollowed, _ := getFollowed(db, users[index].Username)
 			if previoslyFollowed != "" {
-				log.Printf("%s previously followed at %s, skipping\n", users[index].Username, previoslyFollowed)
+				taskLogger("scrap").WithFields(logrus.Fields{"username": users[index].Username, "followed": previoslyFollowed}).Debug("previously followed, skipping")
 			} else {
-				log.Printf("Adding %s to queue)", users[index].Username)
+				taskLogger("scrap").WithField("username", users[index].Username).Info("added to queue")
 				addToFollowQueue(db, users[index].Username)
 			}
 		}
 	}
+	return nil
 }
 
-func startFollowFromQueue(db *bolt.DB, limit int) {
+// startFollowFromQueue follows up to limit users from the followqueue bucket
+func startFollowFromQueue(ctx context.Context, db *bolt.DB, limit int) error {
+	ig := withContext(ctx, currentInstaClient())
+	tlog := taskLogger("followQueue")
+
 	var current = 0
 	usersQueue := getUsersFromQueue(db, limit)
 	for index := range usersQueue {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		current++
-		user, err := insta.GetUserByUsername(usersQueue[index])
+		user, err := ig.Profile(usersQueue[index])
 		if err != nil {
-			log.Printf("[%d/%d] %s doesn't exist\n", current, limit, usersQueue[index])
+			if err == context.Canceled {
+				return err
+			}
+			tlog.WithFields(logrus.Fields{"username": usersQueue[index], "current": current, "limit": limit}).Info("not found, skipping")
 			deleteKeyFromBucket(db, "followqueue", usersQueue[index])
 			continue
 		}
-		userFriendShip, err := insta.UserFriendShip(user.User.ID)
+		err = ig.SyncFriendship(user)
+		if err == context.Canceled {
+			return err
+		}
 		check(err)
-		if !userFriendShip.Following {
-			if user.User.IsPrivate {
-				log.Printf("[%d/%d] %s is private, skipping follow\n", current, limit, usersQueue[index])
+		if !user.Friendship.Following {
+			if action, reason := filterCandidate(db, user, nil); action != filterFollow {
+				tlog.WithFields(logrus.Fields{"username": usersQueue[index], "current": current, "limit": limit, "reason": reason}).Debug("filtered out, skipping follow")
 			} else {
-				log.Printf("[%d/%d] Following %s\n", current, limit, usersQueue[index])
-				resp, err := insta.Follow(user.User.ID)
+				if err := takeBudget(ctx, db, "follow"); err != nil {
+					return err
+				}
+				tlog.WithFields(logrus.Fields{"username": usersQueue[index], "current": current, "limit": limit, "action": "follow"}).Info("following")
+				err := ig.Follow(user)
+				audit(db, "follow", usersQueue[index], "followQueue", "queue", err)
 				if err != nil {
-					log.Println(err)
-				} else {
-					userFriendShip.Following = resp.FriendShipStatus.Following
-
-					if !userFriendShip.Following {
-						log.Println("Not followed")
+					refundBudget(db, "follow")
+					if err == context.Canceled {
+						return err
 					}
+					tlog.WithFields(logrus.Fields{"username": usersQueue[index], "action": "follow"}).WithError(err).Error("follow failed")
+				} else if !user.Friendship.Following {
+					tlog.WithField("username", usersQueue[index]).Warn("not followed")
 				}
 			}
-			if userFriendShip.Following {
+			if user.Friendship.Following {
 				numFollowed++
-				incStats(db, "refollow")
-				setFollowed(db, usersQueue[index])
-				time.Sleep(16 * time.Second)
+				incStats(db, "follow", "queue")
+				setFollowed(db, usersQueue[index], "queue", "", false)
 			} else {
-				time.Sleep(2 * time.Second)
+				if err := sleep(ctx, 2*time.Second); err != nil {
+					return err
+				}
 			}
 		} else {
-			log.Printf("[%d/%d] Already following %s\n", current, limit, usersQueue[index])
+			tlog.WithFields(logrus.Fields{"username": usersQueue[index], "current": current, "limit": limit}).Debug("already following")
 		}
 		deleteKeyFromBucket(db, "followqueue", usersQueue[index])
 	}
+
+	return nil
 }
 
 // func likeFollowersStories(db *bolt.DB) {
@@ -1935,11 +1644,17 @@ func startFollowFromQueue(db *bolt.DB, limit int) {
 // }
 
 func getStatus() (result string) {
-	userinfo, err := insta.Profiles.ByName(insta.Account.Username)
+	if !loggedIn() {
+		return errNotLoggedIn.Error()
+	}
+	client := currentInstaClient()
+	userinfo, err := withContext(context.Background(), client).Profile(client.Username())
 	if err != nil {
-		log.Println("getStatus", insta.Account.Username, err)
+		logger.WithField("username", client.Username()).WithError(err).Error("can't get status")
 	} else {
 		result = fmt.Sprintf("🖼%d, 👀%d, 🐾%d", userinfo.MediaCount, userinfo.FollowerCount, userinfo.FollowingCount)
+		followersGauge.Set(float64(userinfo.FollowerCount))
+		followingGauge.Set(float64(userinfo.FollowingCount))
 	}
 
 	return
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/spf13/viper"
+)
+
+// addPoster registers a user with a follower and a followed user, so the potency ratio is known,
+// and puts a post of them into the tag feed
+func addPoster(fake *fakeInstagram, tag, username, postID string) {
+	fake.AddUser(goinsta.User{Username: username})
+	fake.AddUser(goinsta.User{Username: username + "_friend"})
+	fake.SetFollows(username, username+"_friend")
+	fake.SetFollows(username+"_friend", username)
+	fake.AddTagPost(tag, username, goinsta.Item{ID: postID, Code: postID, Likes: 10, CommentCount: 1})
+}
+
+func setTestLimits() {
+	likeLowerLimit, likeUpperLimit, likeCount = 0, 1000, 10
+	commentLowerLimit, commentUpperLimit, commentCount = 0, 1000, 0
+	followCount = 10
+	potencyRatio = 0.5
+	maxLikesToAccountPerSession = 10
+}
+
+func TestLoopTagsSkipsFailedFollow(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+	setTestLimits()
+
+	if _, err := changeList(db, "tags", []string{"cats"}, false, "test"); err != nil {
+		t.Fatal(err)
+	}
+	addPoster(fake, "cats", "alice", "1")
+	addPoster(fake, "cats", "bob", "2")
+	fake.Fail("Follow bob", errors.New("follow failed"))
+
+	if err := loopTags(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []string{"like 1", "follow alice", "like 2"} {
+		if !stringInStringSlice(action, fake.Actions) {
+			t.Errorf("%q is not done, actions: %v", action, fake.Actions)
+		}
+	}
+	if stringInStringSlice("follow bob", fake.Actions) {
+		t.Errorf("bob is followed, actions: %v", fake.Actions)
+	}
+
+	if followed, _ := getFollowed(db, "alice"); followed == "" {
+		t.Error("alice is not saved as followed")
+	}
+	if followed, _ := getFollowed(db, "bob"); followed != "" {
+		t.Errorf("bob is saved as followed after a failed follow: %s", followed)
+	}
+	if count, _ := getStats(db, "follow", "tag"); count != 1 {
+		t.Errorf("follow stats = %d, want 1", count)
+	}
+	if count, _ := getStats(db, "like", "tag"); count != 2 {
+		t.Errorf("like stats = %d, want 2", count)
+	}
+	if usage, _ := getBudgetUsage(db, "follow"); usage.DayCount != 1 {
+		t.Errorf("follow budget counts %d, want only the successful follow", usage.DayCount)
+	}
+}
+
+func TestLoopTagsLikesWhenFollowBudgetIsUsedUp(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+	setTestLimits()
+	viper.Set("budget.follow.per_day", 1)
+	defer viper.Set("budget.follow.per_day", 0)
+
+	if _, err := changeList(db, "tags", []string{"cats"}, false, "test"); err != nil {
+		t.Fatal(err)
+	}
+	addPoster(fake, "cats", "alice", "1")
+	addPoster(fake, "cats", "bob", "2")
+
+	if err := loopTags(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, _ := getStats(db, "follow", "tag"); count != 1 {
+		t.Errorf("follow stats = %d, want 1", count)
+	}
+	if count, _ := getStats(db, "like", "tag"); count != 2 {
+		t.Errorf("like stats = %d, want 2 after the follow budget is used up", count)
+	}
+}
+
+func TestLikeImageSkipsCountersOnError(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+
+	fake.AddUser(goinsta.User{Username: "alice"})
+	fake.Fail("Like", errors.New("like failed"))
+	report = map[string]map[string]int{"cats": {}}
+	likesToAccountPerSession = make(map[string]int)
+	numLiked = 0
+
+	err := likeImage(context.Background(), withContext(context.Background(), fake), "cats", db, goinsta.Item{ID: "1", Code: "1"}, goinsta.User{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if numLiked != 0 || report["cats"]["like"] != 0 || likesToAccountPerSession["alice"] != 0 {
+		t.Errorf("failed like is counted: numLiked %d, report %d, per account %d", numLiked, report["cats"]["like"], likesToAccountPerSession["alice"])
+	}
+	if count, _ := getStats(db, "like", "tag"); count != 0 {
+		t.Errorf("like stats = %d, want 0", count)
+	}
+}
+
+func TestFollowUserRecordsOnlySuccess(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+
+	fake.AddUser(goinsta.User{Username: "alice"})
+	fake.AddUser(goinsta.User{Username: "bob"})
+	fake.Fail("Follow bob", errors.New("follow failed"))
+	report = map[string]map[string]int{"cats": {}}
+	numFollowed = 0
+
+	ig := withContext(context.Background(), fake)
+	for _, username := range []string{"alice", "bob"} {
+		user, err := ig.Profile(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := followUser(context.Background(), ig, "cats", db, *user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if numFollowed != 1 || report["cats"]["follow"] != 1 {
+		t.Errorf("numFollowed %d, report %d, want 1", numFollowed, report["cats"]["follow"])
+	}
+	if followed, _ := getFollowed(db, "bob"); followed != "" {
+		t.Errorf("bob is saved as followed after a failed follow: %s", followed)
+	}
+}
+
+func TestFollowFollowersReadsAllPages(t *testing.T) {
+	db, fake, cleanup := newTestEnv(t)
+	defer cleanup()
+	viper.Set("budget.follow.per_day", 3)
+	defer viper.Set("budget.follow.per_day", 0)
+
+	fake.pageSize = 2
+	fake.AddUser(goinsta.User{Username: "star"})
+	for _, username := range []string{"a", "b", "c", "d", "e"} {
+		fake.AddUser(goinsta.User{Username: username})
+		fake.SetFollows("star", username)
+	}
+
+	if err := followFollowers(context.Background(), db, "star"); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, _ := getStats(db, "follow", "refollow"); count != 3 {
+		t.Errorf("followed %d users, want the daily budget of 3: %v", count, fake.Actions)
+	}
+}
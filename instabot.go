@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
-	"net"
-	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -16,9 +15,7 @@ import (
 	"github.com/boltdb/bolt"
 
 	"github.com/fsnotify/fsnotify"
-	"github.com/spf13/viper"
 	"github.com/tevino/abool"
-	"golang.org/x/net/proxy"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
@@ -55,98 +52,93 @@ var (
 	followLikersIsStarted = abool.New()
 	followQueueIsStarted  = abool.New()
 
-	cronFollow      int
-	cronUnfollow    int
-	cronStats       int
-	cronLike        int
-	cronFollowQueue int
-	cronRefollow    int
-
 	l sync.RWMutex
 )
 var db *bolt.DB
 
 func main() {
+	setup()
+
 	editMessage["follow"] = make(map[int]int)
 	editMessage["unfollow"] = make(map[int]int)
 	editMessage["refollow"] = make(map[int]int)
 	editMessage["followLikers"] = make(map[int]int)
+	editMessage["progress"] = make(map[int]int)
+
+	if flag.Arg(0) == "restore" {
+		if err := restoreDatabase(flag.Arg(1)); err != nil {
+			logger.WithError(err).Fatal("restore failed")
+		}
+		return
+	}
 
 	db, err := initBolt()
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Error("can't open database")
 		return
 	}
 	defer db.Close()
 
+	if *migrateDryRun {
+		return
+	}
+
+	startMetrics(db)
+	configureNotifiers()
+
 	c := cron.New()
 	c.Start()
 	defer c.Stop()
 
-	go login()
-
-	defer insta.Logout()
+	defer func() {
+		if session := currentInsta(); session != nil {
+			session.Logout()
+		}
+	}()
 
 	telegramResp = make(chan telegramResponse)
 
-	startFollowChan, _, _, stopFollowChan := followManager(db)
-	startUnfollowChan, _, _, stopUnfollowChan := unfollowManager(db)
-	startRefollowChan, _, innerRefollowChan, stopRefollowChan := refollowManager(db)
-	startfollowLikersChan, _, innerfollowLikersChan, stopFollowLikersChan := followLikersManager(db)
-
-	var tr http.Transport
-
-	if telegramProxy != "" {
-		tr = http.Transport{
-			DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
-				socksDialer, err := proxy.SOCKS5(
-					"tcp",
-					fmt.Sprintf("%s:%d", telegramProxy, telegramProxyPort),
-					&proxy.Auth{User: telegramProxyUser, Password: telegramProxyPassword},
-					proxy.Direct,
-				)
-				if err != nil {
-					log.Println(err)
-					return nil, err
-				}
-
-				return socksDialer.Dial(network, addr)
-			},
-		}
-	}
+	runner := newTaskRunner()
+	registerTasks(runner, db)
 
-	bot, err := tgbotapi.NewBotAPIWithClient(telegramToken, &http.Client{
-		Transport: &tr,
-	})
+	startAPI(db, runner)
 
+	var bot Messenger
+	bot, err = newTelegramBot(telegramToken)
 	if err != nil {
-		log.Println(err)
-		return
+		if !apiEnabled() {
+			logger.WithError(err).Error("can't connect to Telegram")
+			return
+		}
+		logger.WithError(err).Warn("Telegram is unavailable, only the API is served")
+		bot = logMessenger{}
 	}
 
-	bot.Debug = false
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	setNotifyBot(bot)
+	notify(severityInfo, "", "Starting...")
 
-	msg := tgbotapi.NewMessage(int64(reportID), "Starting...")
-	msg.DisableNotification = true
-	bot.Send(msg)
+	// after the notifiers, so a code asked by Instagram reaches admins
+	go login()
 
-	var ucfg = tgbotapi.NewUpdate(0)
-	ucfg.Timeout = 60
+	initCooldown(db)
+	notifySessions(bot, db)
 
-	updates, err := bot.GetUpdatesChan(ucfg)
+	updates, err := bot.Updates()
 	if err != nil {
-		log.Fatalf("[INIT] [Failed to init Telegram updates chan: %v]", err)
+		logger.WithError(err).Fatal("failed to init Telegram updates chan")
 	}
 
-	cronFollow, _ = c.AddFunc("0 0 9 * * *", func() { fmt.Println("Start follow"); startFollow(bot, startFollowChan, reportID) })
-	cronUnfollow, _ = c.AddFunc("0 1 0 * * *", func() { fmt.Println("Start unfollow"); startUnfollow(bot, startUnfollowChan, reportID) })
-	cronStats, _ = c.AddFunc("0 59 23 * * *", func() { fmt.Println("Send stats"); sendStats(bot, db, c, -1) })
-	cronLike, _ = c.AddFunc("0 30 10-21 * * *", func() { fmt.Println("Like followers"); likeFollowersPosts(db) })
-	cronRefollow, _ = c.AddFunc("0 0 11-21 * * *", func() { fmt.Println("Start refollow"); startFollowFromQueue(db, 100) })
+	jobScheduler = newScheduler(c)
+	jobScheduler.Register("follow", "0 0 9 * * *", "", func(string) { startTask(bot, runner, "follow", "", reportID) })
+	jobScheduler.Register("unfollow", "0 1 0 * * *", "", func(string) { startTask(bot, runner, "unfollow", "", reportID) })
+	jobScheduler.Register("stats", "0 59 23 * * *", "", func(string) { sendStats(bot, db, c, "", -1) })
+	jobScheduler.Register("like", "0 30 10-21 * * *", "", func(string) { check(runner.Start("like", "")) })
+	jobScheduler.Register("backup", "0 0 4 * * *", "", func(string) { backupJob(db) })
+	jobScheduler.Register("followqueue", "0 0 11-21 * * *", "100", func(args string) { check(runner.Start("followQueue", args)) })
+	check(jobScheduler.Apply(getScheduleConfig()))
 
 	for _, task := range c.Entries() {
-		log.Println(task.Next)
+		logger.WithField("next", task.Next).Debug("scheduled job")
 	}
 
 	go func() {
@@ -154,193 +146,82 @@ func main() {
 		signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGQUIT)
 		<-sigchan
 
-		msg := tgbotapi.NewMessage(int64(reportID), "Stopping...")
-		msg.DisableNotification = true
-		bot.Send(msg)
+		notify(severityInfo, "", "Stopping...")
+		runner.CancelAll()
 		time.Sleep(3 * time.Second)
 		os.Exit(0)
 	}()
 
-	for {
-		select {
-		case update := <-updates:
-			if update.EditedMessage != nil {
-				continue
-			}
-
-			if intInStringSlice(int(update.Message.From.ID), admins) {
-
-				text := update.Message.Text
-				command := update.Message.Command()
-				args := update.Message.CommandArguments()
-
-				msg := tgbotapi.NewMessage(int64(update.Message.From.ID), "")
-				msg.DisableWebPagePreview = true
-				msg.DisableNotification = true
-
-				switch command {
-				case "relogin":
-					err = createAndSaveSession()
-					if err != nil {
-						msg.Text = fmt.Sprintf("relogin failed with error %s", err)
-					} else {
-						msg.Text = fmt.Sprintf("relogin done")
-					}
-					bot.Send(msg)
-				case "refollow":
-					if args == "" {
-						msg.Text = fmt.Sprintf("/refollow username")
-						bot.Send(msg)
-					} else {
-						startRefollow(bot, startRefollowChan, innerRefollowChan, int64(update.Message.From.ID), args)
-					}
-				case "followlikers":
-					if args == "" {
-						msg.Text = fmt.Sprintf("/followlikers post link")
-						bot.Send(msg)
-					} else {
-						startFollowLikers(bot, startfollowLikersChan, innerfollowLikersChan, int64(update.Message.From.ID), args)
-					}
-				case "follow":
-					startFollow(bot, startFollowChan, int64(update.Message.From.ID))
-				case "unfollow":
-					startUnfollow(bot, startUnfollowChan, int64(update.Message.From.ID))
-				case "progress":
-					var unfollowProgress = "not started"
-					if state["unfollow"] >= 0 {
-						unfollowProgress = fmt.Sprintf("%d%% [%d/%d]", state["unfollow"], state["unfollow_current"], state["unfollow_all_count"])
-					}
-					var followProgress = "not started"
-					if state["follow"] >= 0 {
-						followProgress = fmt.Sprintf("%d%% [%d/%d]", state["follow"], state["follow_current"], state["follow_all_count"])
-					}
-					var refollowProgress = "not started"
-					if state["refollow"] >= 0 {
-						refollowProgress = fmt.Sprintf("%d%% [%d/%d]", state["refollow"], state["refollow_current"], state["refollow_all_count"])
-					}
-					var followLikersProgress = "not started"
-					if state["followLikers"] >= 0 {
-						followLikersProgress = fmt.Sprintf("%d%% [%d/%d]", state["followLikers"], state["followLikers_current"], state["followLikers_all_count"])
-					}
-					msg.Text = fmt.Sprintf("Unfollow — %s\nFollow — %s\nRefollow — %s\nfollowLikers - %s", unfollowProgress, followProgress, refollowProgress, followLikersProgress)
-					msgRes, err := bot.Send(msg)
-					if err != nil {
-						l.Lock()
-						editMessage["progress"][update.Message.From.ID] = msgRes.MessageID
-						l.Unlock()
-					}
-				case "cancelfollow":
-					if followIsStarted.IsSet() {
-						stopFollowChan <- true
-					}
-				case "cancelunfollow":
-					if unfollowIsStarted.IsSet() {
-						stopUnfollowChan <- true
-					}
-				case "cancelrefollow":
-					if refollowIsStarted.IsSet() {
-						stopRefollowChan <- true
-					}
-				case "cancelfollowlikers":
-					if followLikersIsStarted.IsSet() {
-						stopFollowLikersChan <- true
-					}
-				case "stats":
-					sendStats(bot, db, c, int64(update.Message.From.ID))
-				case "getcomments":
-					sendComments(bot, int64(update.Message.From.ID))
-				case "addcomments":
-					addComments(bot, args, int64(update.Message.From.ID))
-				case "removecomments":
-					removeComments(bot, args, int64(update.Message.From.ID))
-				case "gettags":
-					sendTags(bot, int64(update.Message.From.ID))
-				case "addtags":
-					addTags(bot, args, int64(update.Message.From.ID))
-				case "removetags":
-					removeTags(bot, args, int64(update.Message.From.ID))
-				case "getwhitelist":
-					sendWhitelist(bot, int64(update.Message.From.ID))
-				case "addwhitelist":
-					addWhitelist(bot, args, int64(update.Message.From.ID))
-				case "removewhitelist":
-					removeWhitelist(bot, args, int64(update.Message.From.ID))
-				case "getlimits":
-					getLimits(bot, int64(update.Message.From.ID))
-				case "updatelimits":
-					updateLimits(bot, args, int64(update.Message.From.ID))
-				case "updateproxy":
-					updateProxy(bot, args, int64(update.Message.From.ID))
-				case "like":
-					likeFollowersPosts(db)
-				case "watch":
-					if args == "" {
-						msg.Text = fmt.Sprintf("/watch add | del | list")
-						bot.Send(msg)
-					} else {
-						watch(bot, db, args, int64(update.Message.From.ID))
-						//addWatching(bot, db, args, int64(update.Message.From.ID))
-					}
-				case "startfollowqueue":
-					startFollowFromQueue(db, 100)
-					//getUsersFromQueue(db, 1)
-					//iterateDB(db, []byte("followqueue"))
-				case "queuesize":
-					sendQueueSize(bot, db, int64(update.Message.From.ID), "followqueue")
-				case "scrap":
-					watchinguser, _ := getWatchingUser(db)
-					scrapFollowersFromUser(db, watchinguser)
-
-				default:
-					msg.Text = text
-					msg.ReplyMarkup = commandKeyboard
-					bot.Send(msg)
-				}
-			}
-		case resp := <-telegramResp:
-			log.Println(resp.key, resp.body)
-			if resp.key != "" {
-				l.RLock()
-				ln := len(editMessage[resp.key])
-				l.RUnlock()
-				if ln > 0 {
-					l.RLock()
-					rn := editMessage[resp.key]
-					l.RUnlock()
-					for UserID, EditID := range rn {
-						edit := tgbotapi.EditMessageTextConfig{
-							BaseEdit: tgbotapi.BaseEdit{
-								ChatID:    int64(UserID),
-								MessageID: EditID,
-							},
-							Text: resp.body,
-						}
-						bot.Send(edit)
-					}
-				} else {
-					msg := tgbotapi.NewMessage(reportID, resp.body)
-					msgRes, err := bot.Send(msg)
-					if err == nil {
-						l.Lock()
-						editMessage[resp.key][int(reportID)] = msgRes.MessageID
-						l.Unlock()
-					}
-				}
-			}
-		}
+	d := &dispatcher{
+		bot:  bot,
+		db:   db,
+		cron: c,
+
+		runner: runner,
 	}
+	d.run(updates)
 }
 
-func init() {
+// registerTasks adds all bot tasks to the runner
+func registerTasks(runner *taskRunner, db *bolt.DB) {
+	runner.Register("follow", followIsStarted, requireLogin(withSession(runner, db, "follow", func(ctx context.Context, _ string) error {
+		return loopTags(ctx, db)
+	})))
+	runner.Register("unfollow", unfollowIsStarted, requireLogin(func(ctx context.Context, _ string) error {
+		return syncFollowers(ctx, db)
+	}))
+	runner.Register("refollow", refollowIsStarted, requireLogin(withSession(runner, db, "refollow", func(ctx context.Context, username string) error {
+		return followFollowers(ctx, db, username)
+	})))
+	runner.Register("followLikers", followLikersIsStarted, requireLogin(withSession(runner, db, "followLikers", func(ctx context.Context, link string) error {
+		return followLikers(ctx, db, link)
+	})))
+	runner.Register("followQueue", followQueueIsStarted, requireLogin(func(ctx context.Context, arg string) error {
+		limit, err := strconv.Atoi(arg)
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		return startFollowFromQueue(ctx, db, limit)
+	}))
+	runner.Register("like", nil, requireLogin(func(ctx context.Context, _ string) error {
+		likeFollowersPosts(db)
+		return nil
+	}))
+	runner.Register("scrap", nil, requireLogin(func(ctx context.Context, _ string) error {
+		username, err := getWatchingUser(ctx, db)
+		if err != nil || username == "" {
+			return err
+		}
+		return scrapFollowersFromUser(ctx, db, username)
+	}))
+}
+
+// setup parses the options, reads the config and starts watching it. It is called by main and not by init,
+// so tests can run without a config file.
+func setup() {
 	initKeyboard()
 	parseOptions()
 	getConfig()
+	if err := initLogging(*logToFile); err != nil {
+		logger.WithError(err).Fatal("can't init logging")
+	}
 
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		fmt.Println("Config file changed:", e.Name)
-		getConfig()
+	err := watchConfig(func(e fsnotify.Event) {
+		logger.WithField("file", e.Name).Info("config file changed")
+		if err := reloadConfig(); err != nil {
+			logger.WithError(err).Error("config file is rejected, the previous config is kept")
+			notify(severityWarning, "", fmt.Sprintf("%s is rejected, the previous config is kept:\n%s", e.Name, err))
+			return
+		}
+		check(configureLogger())
+		configureNotifiers()
+		if jobScheduler != nil {
+			check(jobScheduler.Apply(getScheduleConfig()))
+		}
 	})
+	if err != nil {
+		logger.WithError(err).Error("can't watch config file, changes need a restart")
+	}
 }
 
 func initKeyboard() {
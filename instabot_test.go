@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/spf13/viper"
+)
+
+// newTestEnv prepares the globals used by tasks: an empty database (also set as db), fakeInstagram as the client,
+// no pauses between actions and a reader of progress reports. The returned func cleans up.
+func newTestEnv(t *testing.T) (*bolt.DB, *fakeInstagram, func()) {
+	dir, err := ioutil.TempDir("", "instabot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev = new(bool)
+	migrateDryRun = new(bool)
+	dbPath = filepath.Join(dir, "instabot.db")
+	db, err = initBolt()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	for _, action := range budgetActions {
+		viper.Set("budget."+action+".spacing", "0s")
+		viper.Set("budget."+action+".jitter", "0s")
+	}
+
+	instaUsername = "me"
+	fake := newFakeInstagram(instaUsername)
+	instaClient = fake
+
+	resp := make(chan telegramResponse)
+	telegramResp = resp
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-resp:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	opened := db
+	return opened, fake, func() {
+		close(done)
+		opened.Close()
+		os.RemoveAll(dir)
+	}
+}
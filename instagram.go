@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ahmdrz/goinsta/v2"
+)
+
+// InstagramClient is everything the tasks need from Instagram.
+// The real implementation wraps a goinsta session, fakeInstagram keeps everything in memory.
+type InstagramClient interface {
+	// Username returns the name of the logged in account
+	Username() string
+
+	// Profile returns a user profile by username
+	Profile(username string) (*goinsta.User, error)
+	// SyncFriendship refreshes user.Friendship
+	SyncFriendship(user *goinsta.User) error
+	// Followers returns a pager over the followers of user
+	Followers(user *goinsta.User) UserPager
+	// Following returns a pager over the users followed by user
+	Following(user *goinsta.User) UserPager
+
+	// TagFeed returns the first page of the hashtag feed
+	TagFeed(tag string) ([]goinsta.Item, error)
+	// UserFeed returns up to limit latest posts of user
+	UserFeed(user *goinsta.User, limit int) ([]goinsta.Item, error)
+	// Media returns a post by media id
+	Media(mediaID string) (*goinsta.Item, error)
+	// Likers returns the users who liked the post
+	Likers(item *goinsta.Item) ([]goinsta.User, error)
+
+	Follow(user *goinsta.User) error
+	Unfollow(user *goinsta.User) error
+	Like(item *goinsta.Item) error
+}
+
+// UserPager iterates over a paginated list of users
+type UserPager interface {
+	// Next loads the next page, returns false when there is nothing left
+	Next() bool
+	// Users returns the current page
+	Users() []goinsta.User
+	// Error returns the error which stopped the pagination, if any
+	Error() error
+}
+
+// allUsers walks through all pages and returns every user
+func allUsers(pager UserPager) []goinsta.User {
+	users := make([]goinsta.User, 0)
+	for pager.Next() {
+		users = append(users, pager.Users()...)
+	}
+	return users
+}
+
+// goinstaClient is InstagramClient backed by a goinsta session
+type goinstaClient struct {
+	insta *goinsta.Instagram
+}
+
+func newGoinstaClient(insta *goinsta.Instagram) *goinstaClient {
+	return &goinstaClient{insta: insta}
+}
+
+func (c *goinstaClient) Username() string {
+	return c.insta.Account.Username
+}
+
+func (c *goinstaClient) Profile(username string) (*goinsta.User, error) {
+	return c.insta.Profiles.ByName(username)
+}
+
+func (c *goinstaClient) SyncFriendship(user *goinsta.User) error {
+	return user.FriendShip()
+}
+
+func (c *goinstaClient) Followers(user *goinsta.User) UserPager {
+	return &goinstaUserPager{users: user.Followers()}
+}
+
+func (c *goinstaClient) Following(user *goinsta.User) UserPager {
+	return &goinstaUserPager{users: user.Following()}
+}
+
+func (c *goinstaClient) TagFeed(tag string) ([]goinsta.Item, error) {
+	feedTag, err := c.insta.Feed.Tags(tag)
+	if err != nil {
+		return nil, err
+	}
+	return feedTag.Images, nil
+}
+
+func (c *goinstaClient) UserFeed(user *goinsta.User, limit int) ([]goinsta.Item, error) {
+	items := make([]goinsta.Item, 0)
+	feed := user.Feed()
+	for feed.Next() {
+		items = append(items, feed.Items...)
+		if len(items) >= limit {
+			return items[:limit], nil
+		}
+	}
+	if err := feed.Error(); err != nil && err != goinsta.ErrNoMore {
+		return items, err
+	}
+	return items, nil
+}
+
+func (c *goinstaClient) Media(mediaID string) (*goinsta.Item, error) {
+	media, err := c.insta.GetMedia(mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if len(media.Items) == 0 {
+		return nil, fmt.Errorf("media %s not found", mediaID)
+	}
+	return &media.Items[0], nil
+}
+
+func (c *goinstaClient) Likers(item *goinsta.Item) ([]goinsta.User, error) {
+	if err := item.SyncLikers(); err != nil {
+		return nil, err
+	}
+	return item.Likers, nil
+}
+
+func (c *goinstaClient) Follow(user *goinsta.User) error {
+	return user.Follow()
+}
+
+func (c *goinstaClient) Unfollow(user *goinsta.User) error {
+	return user.Unfollow()
+}
+
+func (c *goinstaClient) Like(item *goinsta.Item) error {
+	return item.Like()
+}
+
+type goinstaUserPager struct {
+	users *goinsta.Users
+}
+
+func (p *goinstaUserPager) Next() bool {
+	return p.users.Next()
+}
+
+func (p *goinstaUserPager) Users() []goinsta.User {
+	return p.users.Users
+}
+
+func (p *goinstaUserPager) Error() error {
+	if err := p.users.Error(); err != goinsta.ErrNoMore {
+		return err
+	}
+	return nil
+}
+
+// withContext wraps client so every call returns ctx.Err() as soon as ctx is cancelled.
+// goinsta can't abort a request, so an interrupted call finishes in background and its result is dropped.
+func withContext(ctx context.Context, client InstagramClient) InstagramClient {
+	return &contextClient{ctx: ctx, client: client}
+}
+
+type contextClient struct {
+	ctx    context.Context
+	client InstagramClient
+}
+
+func (c *contextClient) call(fn func() error) error {
+	if err := waitCooldown(c.ctx); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		handleInstagramError(err)
+		return err
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c *contextClient) Username() string {
+	return c.client.Username()
+}
+
+func (c *contextClient) Profile(username string) (*goinsta.User, error) {
+	var user *goinsta.User
+	err := c.call(func() (err error) {
+		user, err = c.client.Profile(username)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (c *contextClient) SyncFriendship(user *goinsta.User) error {
+	return c.call(func() error { return c.client.SyncFriendship(user) })
+}
+
+func (c *contextClient) Followers(user *goinsta.User) UserPager {
+	return &contextPager{client: c, pager: c.client.Followers(user)}
+}
+
+func (c *contextClient) Following(user *goinsta.User) UserPager {
+	return &contextPager{client: c, pager: c.client.Following(user)}
+}
+
+func (c *contextClient) TagFeed(tag string) ([]goinsta.Item, error) {
+	var items []goinsta.Item
+	err := c.call(func() (err error) {
+		items, err = c.client.TagFeed(tag)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (c *contextClient) UserFeed(user *goinsta.User, limit int) ([]goinsta.Item, error) {
+	var items []goinsta.Item
+	err := c.call(func() (err error) {
+		items, err = c.client.UserFeed(user, limit)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (c *contextClient) Media(mediaID string) (*goinsta.Item, error) {
+	var item *goinsta.Item
+	err := c.call(func() (err error) {
+		item, err = c.client.Media(mediaID)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (c *contextClient) Likers(item *goinsta.Item) ([]goinsta.User, error) {
+	var users []goinsta.User
+	err := c.call(func() (err error) {
+		users, err = c.client.Likers(item)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (c *contextClient) Follow(user *goinsta.User) error {
+	return c.call(func() error { return c.client.Follow(user) })
+}
+
+func (c *contextClient) Unfollow(user *goinsta.User) error {
+	return c.call(func() error { return c.client.Unfollow(user) })
+}
+
+func (c *contextClient) Like(item *goinsta.Item) error {
+	return c.call(func() error { return c.client.Like(item) })
+}
+
+// contextPager stops paginating once the context is cancelled
+type contextPager struct {
+	client *contextClient
+	pager  UserPager
+	err    error
+}
+
+func (p *contextPager) Next() bool {
+	var next bool
+	err := p.client.call(func() error {
+		next = p.pager.Next()
+		return nil
+	})
+	if err != nil {
+		p.err = err
+		return false
+	}
+	if !next {
+		handleInstagramError(p.pager.Error())
+	}
+	return next
+}
+
+func (p *contextPager) Users() []goinsta.User {
+	return p.pager.Users()
+}
+
+func (p *contextPager) Error() error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.pager.Error()
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ahmdrz/goinsta/v2"
+)
+
+// fakeInstagram is an in-memory InstagramClient for running the tasks without network.
+// Fill profiles, relations, feeds and likers, script failures with Fail,
+// then inspect Actions to see what the bot did.
+type fakeInstagram struct {
+	mu sync.Mutex
+
+	username string
+	pageSize int
+
+	profiles  map[string]*goinsta.User
+	followers map[string][]string
+	following map[string][]string
+	tagFeeds  map[string][]goinsta.Item
+	userFeeds map[string][]goinsta.Item
+	media     map[string]*goinsta.Item
+	likers    map[string][]string
+	errors    map[string]error
+
+	// Actions is the log of performed actions, like "follow foo" or "like 123"
+	Actions []string
+	// Lookups is the log of fetched profiles
+	Lookups []string
+}
+
+func newFakeInstagram(username string) *fakeInstagram {
+	f := &fakeInstagram{
+		username:  username,
+		pageSize:  200,
+		profiles:  make(map[string]*goinsta.User),
+		followers: make(map[string][]string),
+		following: make(map[string][]string),
+		tagFeeds:  make(map[string][]goinsta.Item),
+		userFeeds: make(map[string][]goinsta.Item),
+		media:     make(map[string]*goinsta.Item),
+		likers:    make(map[string][]string),
+		errors:    make(map[string]error),
+	}
+	f.AddUser(goinsta.User{Username: username})
+	return f
+}
+
+// AddUser registers a profile, the ID is generated if empty
+func (f *fakeInstagram) AddUser(user goinsta.User) *goinsta.User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user.ID == 0 {
+		user.ID = int64(len(f.profiles) + 1)
+	}
+	f.profiles[user.Username] = &user
+	return &user
+}
+
+// SetFollows makes follower follow every one of users
+func (f *fakeInstagram) SetFollows(follower string, users ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, username := range users {
+		f.addRelation(follower, username)
+	}
+}
+
+// AddTagPost puts a post of username into the tag feed
+func (f *fakeInstagram) AddTagPost(tag string, username string, item goinsta.Item) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	item.User = goinsta.User{Username: username}
+	if p, ok := f.profiles[username]; ok {
+		item.User = *p
+	}
+	f.tagFeeds[tag] = append(f.tagFeeds[tag], item)
+}
+
+// AddPost registers a post of username, it is returned by UserFeed and Media
+func (f *fakeInstagram) AddPost(username string, item goinsta.Item, likers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if item.ID == "" {
+		item.ID = fmt.Sprintf("%d_%d", len(f.media)+1, len(username))
+	}
+	item.User = goinsta.User{Username: username}
+	item.Likes = len(likers)
+	f.likers[item.ID] = likers
+	f.userFeeds[username] = append([]goinsta.Item{item}, f.userFeeds[username]...)
+	f.media[item.ID] = &item
+}
+
+// Fail makes the call return err, key is the method name with optional target, e.g. "Follow" or "Follow foo"
+func (f *fakeInstagram) Fail(key string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errors[key] = err
+}
+
+func (f *fakeInstagram) failure(method, target string) error {
+	if err, ok := f.errors[method+" "+target]; ok {
+		return err
+	}
+	return f.errors[method]
+}
+
+func (f *fakeInstagram) addRelation(follower, username string) {
+	if !stringInStringSlice(username, f.following[follower]) {
+		f.following[follower] = append(f.following[follower], username)
+	}
+	if !stringInStringSlice(follower, f.followers[username]) {
+		f.followers[username] = append(f.followers[username], follower)
+	}
+}
+
+func (f *fakeInstagram) removeRelation(follower, username string) {
+	f.following[follower] = removeString(f.following[follower], username)
+	f.followers[username] = removeString(f.followers[username], follower)
+}
+
+func (f *fakeInstagram) user(username string) goinsta.User {
+	if p, ok := f.profiles[username]; ok {
+		user := *p
+		user.FollowerCount = len(f.followers[username])
+		user.FollowingCount = len(f.following[username])
+		user.MediaCount = len(f.userFeeds[username])
+		user.Friendship.Following = stringInStringSlice(username, f.following[f.username])
+		user.Friendship.FollowedBy = stringInStringSlice(f.username, f.following[username])
+		return user
+	}
+	return goinsta.User{Username: username}
+}
+
+func (f *fakeInstagram) users(usernames []string) []goinsta.User {
+	users := make([]goinsta.User, 0, len(usernames))
+	for _, username := range usernames {
+		users = append(users, f.user(username))
+	}
+	return users
+}
+
+func (f *fakeInstagram) Username() string {
+	return f.username
+}
+
+func (f *fakeInstagram) Profile(username string) (*goinsta.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Lookups = append(f.Lookups, username)
+	if err := f.failure("Profile", username); err != nil {
+		return nil, err
+	}
+	if _, ok := f.profiles[username]; !ok {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+	user := f.user(username)
+	return &user, nil
+}
+
+func (f *fakeInstagram) SyncFriendship(user *goinsta.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("SyncFriendship", user.Username); err != nil {
+		return err
+	}
+	user.Friendship = f.user(user.Username).Friendship
+	return nil
+}
+
+func (f *fakeInstagram) Followers(user *goinsta.User) UserPager {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &fakeUserPager{users: f.users(f.followers[user.Username]), size: f.pageSize, err: f.failure("Followers", user.Username)}
+}
+
+func (f *fakeInstagram) Following(user *goinsta.User) UserPager {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &fakeUserPager{users: f.users(f.following[user.Username]), size: f.pageSize, err: f.failure("Following", user.Username)}
+}
+
+func (f *fakeInstagram) TagFeed(tag string) ([]goinsta.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("TagFeed", tag); err != nil {
+		return nil, err
+	}
+	items := make([]goinsta.Item, len(f.tagFeeds[tag]))
+	copy(items, f.tagFeeds[tag])
+	return items, nil
+}
+
+func (f *fakeInstagram) UserFeed(user *goinsta.User, limit int) ([]goinsta.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("UserFeed", user.Username); err != nil {
+		return nil, err
+	}
+	items := f.userFeeds[user.Username]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	result := make([]goinsta.Item, len(items))
+	copy(result, items)
+	return result, nil
+}
+
+func (f *fakeInstagram) Media(mediaID string) (*goinsta.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("Media", mediaID); err != nil {
+		return nil, err
+	}
+	item, ok := f.media[mediaID]
+	if !ok {
+		return nil, fmt.Errorf("media %s not found", mediaID)
+	}
+	result := *item
+	result.Likers = f.users(f.likers[mediaID])
+	return &result, nil
+}
+
+func (f *fakeInstagram) Likers(item *goinsta.Item) ([]goinsta.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("Likers", item.ID); err != nil {
+		return nil, err
+	}
+	item.Likers = f.users(f.likers[item.ID])
+	return item.Likers, nil
+}
+
+func (f *fakeInstagram) Follow(user *goinsta.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("Follow", user.Username); err != nil {
+		return err
+	}
+	f.addRelation(f.username, user.Username)
+	user.Friendship.Following = true
+	f.Actions = append(f.Actions, "follow "+user.Username)
+	return nil
+}
+
+func (f *fakeInstagram) Unfollow(user *goinsta.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("Unfollow", user.Username); err != nil {
+		return err
+	}
+	f.removeRelation(f.username, user.Username)
+	user.Friendship.Following = false
+	f.Actions = append(f.Actions, "unfollow "+user.Username)
+	return nil
+}
+
+func (f *fakeInstagram) Like(item *goinsta.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.failure("Like", item.ID); err != nil {
+		return err
+	}
+	if !stringInStringSlice(f.username, f.likers[item.ID]) {
+		f.likers[item.ID] = append(f.likers[item.ID], f.username)
+	}
+	item.HasLiked = true
+	f.Actions = append(f.Actions, "like "+item.ID)
+	return nil
+}
+
+type fakeUserPager struct {
+	users []goinsta.User
+	size  int
+	page  []goinsta.User
+	err   error
+}
+
+func (p *fakeUserPager) Next() bool {
+	if p.err != nil || len(p.users) == 0 {
+		return false
+	}
+	n := p.size
+	if n <= 0 || n > len(p.users) {
+		n = len(p.users)
+	}
+	p.page, p.users = p.users[:n], p.users[n:]
+	return true
+}
+
+func (p *fakeUserPager) Users() []goinsta.User {
+	return p.page
+}
+
+func (p *fakeUserPager) Error() error {
+	return p.err
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// listNames are the lists kept in the database, each in the bucket of the same name.
+// The lists of config.json only seed them when the database is created or migrated.
+var listNames = []string{"tags", "comments", "whitelist"}
+
+var listTitles = map[string]string{"tags": "Tags", "comments": "Comments", "whitelist": "Whitelist"}
+
+// listHistoryLimit is the number of changes shown by /gettags history and the like
+const listHistoryLimit = 30
+
+// listItem is an entry of a list, removed items are kept to show who changed the list and when
+type listItem struct {
+	Value     string    `json:"value"`
+	AddedAt   time.Time `json:"added_at"`
+	AddedBy   string    `json:"added_by"`
+	RemovedAt time.Time `json:"removed_at,omitempty"`
+	RemovedBy string    `json:"removed_by,omitempty"`
+}
+
+func (i listItem) removed() bool {
+	return !i.RemovedAt.IsZero()
+}
+
+// seedLists creates the list buckets with the lists of config.json
+func seedLists(tx *bolt.Tx) (int, error) {
+	var seeded int
+	now := time.Now()
+	for _, name := range listNames {
+		bk, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return seeded, errors.Wrapf(err, "failed to create '%s' bucket", name)
+		}
+
+		for _, value := range cleanListItems(name, viper.GetStringSlice(name)) {
+			if bk.Get([]byte(value)) != nil {
+				continue
+			}
+			if err := putListItem(bk, listItem{Value: value, AddedAt: now, AddedBy: "config"}); err != nil {
+				return seeded, err
+			}
+			seeded++
+		}
+	}
+	return seeded, nil
+}
+
+// cleanListItems trims the items and drops empty ones, like /addtags the dots are removed from tags and whitelist items
+func cleanListItems(name string, items []string) []string {
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if name != "comments" {
+			item = strings.Replace(item, ".", "", -1)
+		}
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return sliceUnique(result)
+}
+
+func putListItem(bk *bolt.Bucket, item listItem) error {
+	value, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode list item '%s'", item.Value)
+	}
+	return bk.Put([]byte(item.Value), value)
+}
+
+// getListItems returns the items of a list including the removed ones
+func getListItems(db *bolt.DB, name string) ([]listItem, error) {
+	var items []listItem
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(name))
+		if bk == nil {
+			return nil
+		}
+
+		return bk.ForEach(func(k, v []byte) error {
+			var item listItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return errors.Wrapf(err, "invalid %s item '%s'", name, k)
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+// getList returns the values of a list
+func getList(db *bolt.DB, name string) ([]string, error) {
+	items, err := getListItems(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if !item.removed() {
+			values = append(values, item.Value)
+		}
+	}
+	return values, nil
+}
+
+// inList reports whether value is in a list, errors are logged and reported as not found
+func inList(db *bolt.DB, name, value string) bool {
+	var found bool
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte(name))
+		if bk == nil {
+			return nil
+		}
+
+		v := bk.Get([]byte(value))
+		if v == nil {
+			return nil
+		}
+
+		var item listItem
+		if err := json.Unmarshal(v, &item); err != nil {
+			return errors.Wrapf(err, "invalid %s item '%s'", name, value)
+		}
+		found = !item.removed()
+		return nil
+	})
+	if err != nil {
+		logger.WithField("list", name).WithError(err).Error("can't check list")
+	}
+	return found
+}
+
+// changeList adds or removes values in a single transaction, so concurrent changes are not lost.
+// by is who changed the list: a Telegram user ID, "api" or "config". It returns the number of changed items.
+func changeList(db *bolt.DB, name string, values []string, remove bool, by string) (int, error) {
+	var changed int
+	err := db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return errors.Wrapf(err, "failed to get '%s' bucket", name)
+		}
+
+		now := time.Now()
+		for _, value := range cleanListItems(name, values) {
+			var item listItem
+			if v := bk.Get([]byte(value)); v != nil {
+				if err := json.Unmarshal(v, &item); err != nil {
+					return errors.Wrapf(err, "invalid %s item '%s'", name, value)
+				}
+			}
+
+			exists := item.Value != "" && !item.removed()
+			switch {
+			case remove && exists:
+				item.RemovedAt = now
+				item.RemovedBy = by
+			case !remove && !exists:
+				item = listItem{Value: value, AddedAt: now, AddedBy: by}
+			default:
+				continue
+			}
+
+			if err := putListItem(bk, item); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// formatListHistory shows the latest changes of a list
+func formatListHistory(items []listItem) string {
+	type change struct {
+		time time.Time
+		text string
+	}
+
+	changes := make([]change, 0, len(items)*2)
+	for _, item := range items {
+		changes = append(changes, change{item.AddedAt, fmt.Sprintf("%s + %s by %s", item.AddedAt.Format("01-02 15:04"), item.Value, item.AddedBy)})
+		if item.removed() {
+			changes = append(changes, change{item.RemovedAt, fmt.Sprintf("%s − %s by %s", item.RemovedAt.Format("01-02 15:04"), item.Value, item.RemovedBy)})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].time.After(changes[j].time) })
+	if len(changes) > listHistoryLimit {
+		changes = changes[:listHistoryLimit]
+	}
+
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		lines = append(lines, c.text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendList handles /gettags, /getcomments and /getwhitelist, with "history" it shows the latest changes.
+// Tags are shown with their limits.
+func sendList(bot Messenger, db *bolt.DB, name, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+
+	items, err := getListItems(db, name)
+	switch {
+	case err != nil:
+		msg.Text = err.Error()
+	case strings.TrimSpace(args) == "history":
+		msg.Text = formatListHistory(items)
+	default:
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			if !item.removed() {
+				values = append(values, item.Value)
+			}
+		}
+		if name == "tags" {
+			msg.Text, err = formatTags(db, values)
+			if err != nil {
+				msg.Text = err.Error()
+			}
+		} else {
+			msg.Text = strings.Join(values, ", ")
+		}
+	}
+	if msg.Text == "" {
+		msg.Text = listTitles[name] + " is empty"
+	}
+
+	bot.Send(msg)
+}
+
+// updateList handles /addtags, /removetags and the like, items are separated by ", "
+func updateList(bot Messenger, db *bolt.DB, name, args string, remove bool, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+
+	values := cleanListItems(name, strings.Split(args, ", "))
+	if len(values) == 0 {
+		msg.Text = listTitles[name] + " is empty"
+		bot.Send(msg)
+		return
+	}
+
+	changed, err := changeList(db, name, values, remove, strconv.FormatInt(userID, 10))
+	switch {
+	case err != nil:
+		msg.Text = err.Error()
+	case remove:
+		msg.Text = fmt.Sprintf("%s removed: %d", listTitles[name], changed)
+	default:
+		msg.Text = fmt.Sprintf("%s added: %d", listTitles[name], changed)
+	}
+	bot.Send(msg)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// logger is the bot log, use fields (task, tag, username, action) instead of formatting them into the message
+var logger = logrus.New()
+
+// logFile rotates the log file, nil when logs only go to stdout
+var logFile *lumberjack.Logger
+
+// initLogging sets up the logger from the log section of the config, toFile writes logs to log.dir too
+func initLogging(toFile bool) error {
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.format", "text")
+	viper.SetDefault("log.dir", "logs")
+	viper.SetDefault("log.max_size", 10)
+	viper.SetDefault("log.max_backups", 7)
+	viper.SetDefault("log.max_age", 30)
+	viper.SetDefault("log.daily", true)
+
+	if err := configureLogger(); err != nil {
+		return err
+	}
+
+	var output io.Writer = os.Stdout
+	if toFile || viper.GetBool("log.file") {
+		dir := viper.GetString("log.dir")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+
+		logFile = &lumberjack.Logger{
+			Filename:   filepath.Join(dir, "instabot.log"),
+			MaxSize:    viper.GetInt("log.max_size"),
+			MaxBackups: viper.GetInt("log.max_backups"),
+			MaxAge:     viper.GetInt("log.max_age"),
+		}
+		output = io.MultiWriter(os.Stdout, logFile)
+
+		if viper.GetBool("log.daily") {
+			go rotateDaily(logFile)
+		}
+	}
+	logger.SetOutput(output)
+
+	// logs of the libraries and of the standard logger go to the same place
+	log.SetFlags(0)
+	log.SetOutput(logger.WriterLevel(logrus.InfoLevel))
+
+	return nil
+}
+
+// configureLogger applies log.level and log.format, it is called again when the config changes
+func configureLogger() error {
+	level, err := logrus.ParseLevel(viper.GetString("log.level"))
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(level)
+
+	switch format := viper.GetString("log.format"); format {
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "text", "":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("unknown log format %q, should be text or json", format)
+	}
+	return nil
+}
+
+// rotateDaily starts a new log file every midnight, size based rotation works in between
+func rotateDaily(file *lumberjack.Logger) {
+	for {
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		time.Sleep(midnight.Sub(now))
+
+		if err := file.Rotate(); err != nil {
+			logger.WithError(err).Error("can't rotate log file")
+		}
+	}
+}
+
+// taskLogger returns the logger of a task
+func taskLogger(task string) *logrus.Entry {
+	return logger.WithField("task", task)
+}
+
+// updateLogLevel shows the log level or changes it until restart or config change
+func updateLogLevel(bot Messenger, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+	args = strings.TrimSpace(args)
+
+	if args == "" {
+		msg.Text = fmt.Sprintf("log level: %s\n/loglevel debug | info | warning | error", logger.GetLevel())
+		bot.Send(msg)
+		return
+	}
+
+	level, err := logrus.ParseLevel(args)
+	if err != nil {
+		msg.Text = fmt.Sprintf("%s\n/loglevel debug | info | warning | error", err)
+	} else {
+		logger.SetLevel(level)
+		logger.WithField("log_level", level.String()).Info("log level changed")
+		msg.Text = fmt.Sprintf("log level: %s", level)
+	}
+	bot.Send(msg)
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/spf13/viper"
+	"github.com/tevino/abool"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// the login request is made here because goinsta drops the two-factor and challenge details of the response,
+// these are the values goinsta uses for its requests
+const (
+	instaAPIURL     = "https://i.instagram.com/api/v1/"
+	instaUserAgent  = "Instagram 85.0.0.21.100 Android (24/7.0; 380dpi; 1080x1920; OnePlus; ONEPLUS A3010; OnePlus3T; qcom; en_US)"
+	instaAppID      = "567067343352427"
+	instaSigKey     = "937463b5272b5d60e9d20f0f8d7d192193dd95095a3ad43725d494300a5ea5fc"
+	instaSigVersion = "4"
+)
+
+// states of the Instagram login
+const (
+	loginNotStarted = "not started"
+	loginLoggedIn   = "logged in"
+	loginTwoFactor  = "waiting for the two-factor code"
+	loginChallenge  = "waiting for the challenge code"
+	loginFailed     = "failed"
+)
+
+var (
+	loginMu sync.Mutex
+	// loginStatus is shown by /login status
+	loginStatus = struct {
+		State   string
+		Detail  string
+		Changed time.Time
+	}{State: loginNotStarted}
+	// pendingLogin waits for the code asked by Instagram
+	pendingLogin *loginFlow
+	// loginIsStarted is set while a login started from Telegram talks to Instagram
+	loginIsStarted = abool.New()
+)
+
+var errNotLoggedIn = fmt.Errorf("not logged in to Instagram, see /login status")
+
+// loginResponse is the part of the login, two-factor and challenge responses the flow needs
+type loginResponse struct {
+	Status       string `json:"status"`
+	Message      string `json:"message"`
+	ErrorType    string `json:"error_type"`
+	Action       string `json:"action"`
+	StepName     string `json:"step_name"`
+	LoggedInUser struct {
+		PK       int64  `json:"pk"`
+		Username string `json:"username"`
+	} `json:"logged_in_user"`
+	TwoFactorRequired bool `json:"two_factor_required"`
+	TwoFactorInfo     struct {
+		Identifier      string `json:"two_factor_identifier"`
+		ObfuscatedPhone string `json:"obfuscated_phone_number"`
+	} `json:"two_factor_info"`
+	Challenge struct {
+		APIPath string `json:"api_path"`
+	} `json:"challenge"`
+	StepData struct {
+		ContactPoint string `json:"contact_point"`
+	} `json:"step_data"`
+}
+
+// loginFlow is a login which can be continued with a code sent by Instagram
+type loginFlow struct {
+	client   *http.Client
+	username string
+	password string
+	deviceID string
+	uuid     string
+	phoneID  string
+
+	twoFactorID   string
+	challengePath string
+}
+
+func newLoginFlow(username, password, proxy string) (*loginFlow, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &loginFlow{
+		client:   &http.Client{Jar: jar, Transport: transport, Timeout: 30 * time.Second},
+		username: username,
+		password: password,
+		deviceID: "android-" + md5Hex(md5Hex(username+password) + "12345")[:16],
+		uuid:     newUUID(),
+		phoneID:  newUUID(),
+	}, nil
+}
+
+// request sends a signed POST, or a GET when data is nil, and decodes the response whatever the status is
+func (f *loginFlow) request(endpoint string, data map[string]interface{}) (*loginResponse, error) {
+	method := http.MethodGet
+	body := bytes.NewBuffer(nil)
+	if data != nil {
+		method = http.MethodPost
+		data["_csrftoken"] = f.csrfToken()
+		data["guid"] = f.uuid
+		data["device_id"] = f.deviceID
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		form := url.Values{}
+		form.Set("ig_sig_key_version", instaSigVersion)
+		form.Set("signed_body", signPayload(payload)+"."+string(payload))
+		body.WriteString(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, instaAPIURL+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	req.Header.Set("Accept-Language", "en-US")
+	req.Header.Set("User-Agent", instaUserAgent)
+	req.Header.Set("X-IG-App-ID", instaAppID)
+	req.Header.Set("X-IG-Capabilities", "3brTBw==")
+	req.Header.Set("X-IG-Connection-Type", "WIFI")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result loginResponse
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("Invalid status code: %d: %s", resp.StatusCode, raw)
+	}
+	return &result, nil
+}
+
+func (f *loginFlow) csrfToken() string {
+	u, _ := url.Parse(instaAPIURL)
+	for _, cookie := range f.client.Jar.Cookies(u) {
+		if cookie.Name == "csrftoken" {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// login gets the csrf token and sends the credentials
+func (f *loginFlow) login() (*loginResponse, error) {
+	if f.csrfToken() == "" {
+		endpoint := "si/fetch_headers/?challenge_type=signup&guid=" + strings.Replace(f.uuid, "-", "", -1)
+		if _, err := f.request(endpoint, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.request("accounts/login/", map[string]interface{}{
+		"username":            f.username,
+		"password":            f.password,
+		"phone_id":            f.phoneID,
+		"login_attempt_count": 0,
+		"google_tokens":       "[]",
+	})
+}
+
+// requestChallengeCode asks Instagram to send the challenge code by user.instagram.challenge_method (0 — SMS, 1 — email)
+func (f *loginFlow) requestChallengeCode() (*loginResponse, error) {
+	return f.request(f.challengePath, map[string]interface{}{
+		"choice": viper.GetInt("user.instagram.challenge_method"),
+	})
+}
+
+// sendCode completes the two-factor login or the challenge
+func (f *loginFlow) sendCode(code string) (*loginResponse, error) {
+	if f.twoFactorID != "" {
+		return f.request("accounts/two_factor_login/", map[string]interface{}{
+			"username":              f.username,
+			"verification_code":     code,
+			"two_factor_identifier": f.twoFactorID,
+		})
+	}
+
+	resp, err := f.request(f.challengePath, map[string]interface{}{
+		"security_code": code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// after some challenges Instagram only closes the challenge and the login has to be repeated,
+	// which may ask for a new code
+	if resp.Status == "ok" && resp.LoggedInUser.PK == 0 {
+		f.reset()
+		return f.login()
+	}
+	return resp, nil
+}
+
+// reset forgets the two-factor login or the challenge the flow was waiting for
+func (f *loginFlow) reset() {
+	f.twoFactorID = ""
+	f.challengePath = ""
+}
+
+// session turns the logged in flow into a goinsta session
+func (f *loginFlow) session(userID int64) (*goinsta.Instagram, error) {
+	u, _ := url.Parse(instaAPIURL)
+	config, err := json.Marshal(goinsta.ConfigFile{
+		ID:        userID,
+		User:      f.username,
+		DeviceID:  f.deviceID,
+		UUID:      f.uuid,
+		RankToken: fmt.Sprintf("%d_%s", userID, f.uuid),
+		Token:     f.csrfToken(),
+		PhoneID:   f.phoneID,
+		Cookies:   f.client.Jar.Cookies(u),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := goinsta.ImportReader(bytes.NewReader(config))
+	if err != nil {
+		return nil, err
+	}
+	if instaProxy != "" {
+		session.SetProxy(instaProxy, false)
+	}
+	return session, nil
+}
+
+// finishLogin handles the response of a login step: saves the session, or asks admins for a code
+func finishLogin(f *loginFlow, resp *loginResponse, err error) error {
+	if err != nil {
+		setLoginStatus(loginFailed, err.Error(), nil)
+		return err
+	}
+
+	switch {
+	case resp.LoggedInUser.PK != 0:
+		f.reset()
+		session, err := f.session(resp.LoggedInUser.PK)
+		if err != nil {
+			setLoginStatus(loginFailed, err.Error(), nil)
+			return err
+		}
+		setInstaSession(session)
+		logger.WithField("username", resp.LoggedInUser.Username).Info("logged in")
+		if err := exportInstaSession(session); err != nil {
+			logger.WithError(err).Error("can't export session")
+		}
+		setLoginStatus(loginLoggedIn, resp.LoggedInUser.Username, nil)
+		return nil
+
+	case resp.TwoFactorRequired:
+		f.twoFactorID = resp.TwoFactorInfo.Identifier
+		detail := fmt.Sprintf("code sent to %s", resp.TwoFactorInfo.ObfuscatedPhone)
+		setLoginStatus(loginTwoFactor, detail, f)
+		notify(severityWarning, "", fmt.Sprintf("Instagram asks for the two-factor code, %s\nSend /login code 123456", detail))
+		return fmt.Errorf("%s, send /login code 123456", loginTwoFactor)
+
+	case resp.Challenge.APIPath != "" && f.challengePath == "":
+		f.challengePath = strings.TrimPrefix(resp.Challenge.APIPath, "/")
+		step, err := f.requestChallengeCode()
+		if err != nil {
+			setLoginStatus(loginFailed, err.Error(), nil)
+			return err
+		}
+		detail := fmt.Sprintf("code sent to %s", step.StepData.ContactPoint)
+		setLoginStatus(loginChallenge, detail, f)
+		notify(severityWarning, "", fmt.Sprintf("Instagram asks to confirm the login, %s\nSend /login code 123456", detail))
+		return fmt.Errorf("%s, send /login code 123456", loginChallenge)
+	}
+
+	message := resp.Message
+	if message == "" {
+		message = resp.ErrorType
+	}
+	if f.twoFactorID != "" || f.challengePath != "" {
+		// the code was wrong, the same flow waits for another one
+		loginMu.Lock()
+		loginStatus.Detail = message
+		loginMu.Unlock()
+		return fmt.Errorf("code is not accepted: %s", message)
+	}
+
+	err = fmt.Errorf("%s: %s (%s)", resp.Status, message, resp.ErrorType)
+	setLoginStatus(loginFailed, err.Error(), nil)
+	return err
+}
+
+// submitLoginCode continues the login waiting for a code
+func submitLoginCode(code string) error {
+	loginMu.Lock()
+	f := pendingLogin
+	loginMu.Unlock()
+
+	if f == nil {
+		return fmt.Errorf("no login is waiting for a code, /login status")
+	}
+
+	resp, err := f.sendCode(code)
+	return finishLogin(f, resp, err)
+}
+
+func setLoginStatus(state, detail string, pending *loginFlow) {
+	loginMu.Lock()
+	loginStatus.State = state
+	loginStatus.Detail = detail
+	loginStatus.Changed = time.Now()
+	pendingLogin = pending
+	loginMu.Unlock()
+
+	if state == loginFailed {
+		notify(severityWarning, "", "Instagram login failed: "+detail)
+	}
+}
+
+// dropPendingLogin forgets the login waiting for a code, a new login starts from the password
+func dropPendingLogin() {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+
+	if pendingLogin != nil {
+		pendingLogin.reset()
+		pendingLogin = nil
+	}
+}
+
+// setInstaSession makes session the one used by the tasks
+func setInstaSession(session *goinsta.Instagram) {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+
+	insta = session
+	instaClient = newGoinstaClient(session)
+}
+
+// currentInstaClient returns the client of the current session, tasks take it when they start
+// because a new login replaces it
+func currentInstaClient() InstagramClient {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	return instaClient
+}
+
+// currentInsta returns the current goinsta session
+func currentInsta() *goinsta.Instagram {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	return insta
+}
+
+// loggedIn reports whether there is an Instagram session
+func loggedIn() bool {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	return instaClient != nil
+}
+
+// requireLogin fails the task instead of running it without an Instagram session
+func requireLogin(fn taskFunc) taskFunc {
+	return func(ctx context.Context, arg string) error {
+		if !loggedIn() {
+			return errNotLoggedIn
+		}
+		return fn(ctx, arg)
+	}
+}
+
+func formatLoginStatus() string {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+
+	text := "Instagram login: " + loginStatus.State
+	if loginStatus.Detail != "" {
+		text += " — " + loginStatus.Detail
+	}
+	if !loginStatus.Changed.IsZero() {
+		text += fmt.Sprintf("\nsince %s", loginStatus.Changed.Format("2006-01-02 15:04:05"))
+	}
+	if pendingLogin != nil {
+		text += "\nSend /login code 123456"
+	}
+	return text
+}
+
+// updateLogin shows the login status, sends a code asked by Instagram or starts a new login
+func updateLogin(bot Messenger, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0 || fields[0] == "status":
+		msg.Text = formatLoginStatus()
+	case fields[0] == "code" && len(fields) == 2:
+		startLogin(bot, userID, func() string {
+			if err := submitLoginCode(fields[1]); err != nil {
+				return err.Error()
+			}
+			return formatLoginStatus()
+		})
+		return
+	case fields[0] == "retry":
+		startLogin(bot, userID, func() string {
+			if err := createAndSaveSession(); err != nil {
+				return err.Error()
+			}
+			return formatLoginStatus()
+		})
+		return
+	default:
+		msg.Text = "/login status | code 123456 | retry"
+	}
+	bot.Send(msg)
+}
+
+// startLogin runs fn in the background and replies with its result, so the dispatcher keeps handling updates
+// during the requests to Instagram. Only one login runs at a time.
+func startLogin(bot Messenger, userID int64, fn func() string) {
+	msg := tgbotapi.NewMessage(userID, "")
+	if !loginIsStarted.SetToIf(false, true) {
+		msg.Text = "login is already running, see /login status"
+		bot.Send(msg)
+		return
+	}
+
+	go func() {
+		defer loginIsStarted.UnSet()
+		msg.Text = fn()
+		bot.Send(msg)
+	}()
+}
+
+func signPayload(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(instaSigKey))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func md5Hex(text string) string {
+	sum := md5.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&^0xf0 | 0x40
+	b[8] = b[8]&^0xc0 | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
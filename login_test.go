@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDropPendingLoginResetsFlow(t *testing.T) {
+	defer setLoginStatus(loginNotStarted, "", nil)
+
+	f := &loginFlow{username: "me", challengePath: "challenge/1/abc/"}
+	setLoginStatus(loginChallenge, "code sent", f)
+
+	// a wrong code keeps the flow waiting
+	if err := finishLogin(f, &loginResponse{Status: "fail", Message: "invalid code"}, nil); err == nil {
+		t.Fatal("wrong code is accepted")
+	}
+	if f.challengePath == "" {
+		t.Fatal("flow is reset by a wrong code")
+	}
+
+	dropPendingLogin()
+	if f.challengePath != "" || f.twoFactorID != "" {
+		t.Errorf("flow is not reset: challenge %q, two-factor %q", f.challengePath, f.twoFactorID)
+	}
+	loginMu.Lock()
+	pending := pendingLogin
+	loginMu.Unlock()
+	if pending != nil {
+		t.Error("login is still waiting for a code")
+	}
+}
+
+func TestStartLoginDoesNotBlock(t *testing.T) {
+	bot := newFakeMessenger()
+	release := make(chan struct{})
+	startLogin(bot, 1, func() string {
+		<-release
+		return "logged in"
+	})
+
+	// the first login is still waiting for Instagram
+	startLogin(bot, 1, func() string { return "second login" })
+	if texts := bot.Texts(); len(texts) != 1 || !strings.Contains(texts[0], "already running") {
+		t.Fatalf("got %v, want the second login rejected", texts)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for len(bot.Texts()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if texts := bot.Texts(); len(texts) != 2 || texts[1] != "logged in" {
+		t.Errorf("got %v, want the result of the first login", texts)
+	}
+	for loginIsStarted.IsSet() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"github.com/tevino/abool"
+)
+
+var (
+	actionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "instabot_actions_total",
+		Help: "Instagram actions done, by action, task and source.",
+	}, []string{"action", "task", "source"})
+
+	instagramErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "instabot_instagram_errors_total",
+		Help: "Instagram API errors, by type.",
+	}, []string{"type"})
+
+	telegramFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "instabot_telegram_send_failures_total",
+		Help: "Failed Telegram requests, by method.",
+	}, []string{"method"})
+
+	followersGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "instabot_followers",
+		Help: "Followers of the account, updated with the status.",
+	})
+
+	followingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "instabot_following",
+		Help: "Users followed by the account, updated with the status.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(actionsTotal, instagramErrorsTotal, telegramFailuresTotal, followersGauge, followingGauge)
+}
+
+// startMetrics serves /metrics on metrics.listen, nothing is served when it is empty
+func startMetrics(db *bolt.DB) {
+	listen := viper.GetString("metrics.listen")
+	if listen == "" {
+		return
+	}
+
+	tasks := map[string]*abool.AtomicBool{
+		"follow":       followIsStarted,
+		"unfollow":     unfollowIsStarted,
+		"refollow":     refollowIsStarted,
+		"followLikers": followLikersIsStarted,
+		"followQueue":  followQueueIsStarted,
+	}
+	for name, flag := range tasks {
+		flag := flag
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "instabot_task_running",
+			Help:        "1 while the task is running.",
+			ConstLabels: prometheus.Labels{"task": name},
+		}, func() float64 {
+			if flag.IsSet() {
+				return 1
+			}
+			return 0
+		}))
+	}
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "instabot_followqueue_size",
+		Help: "Users waiting in the follow queue.",
+	}, func() float64 {
+		return float64(bucketStats(db, "followqueue").KeyN)
+	}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		logger.WithField("listen", listen).Info("metrics are served on /metrics")
+		if err := http.ListenAndServe(listen, mux); err != nil {
+			logger.WithError(err).Error("metrics server stopped")
+		}
+	}()
+}
+
+// countAction counts a successful action, the source is reduced to its kind to keep the labels bounded
+func countAction(action, task, source string) {
+	switch {
+	case strings.HasPrefix(source, "#"):
+		source = "tag"
+	case strings.HasPrefix(source, "@"):
+		source = "refollow"
+	case strings.HasPrefix(source, "http"):
+		source = "followlikers"
+	}
+	actionsTotal.WithLabelValues(action, task, source).Inc()
+}
+
+// countInstagramError counts a failed Instagram request by its type
+func countInstagramError(err error) {
+	instagramErrorsTotal.WithLabelValues(instagramErrorType(err)).Inc()
+}
+
+func instagramErrorType(err error) string {
+	if class := classifyError(err); class != "" {
+		return class
+	}
+
+	switch e := err.(type) {
+	case goinsta.ErrorN:
+		if e.ErrorType != "" {
+			return e.ErrorType
+		}
+		return "status_" + e.Status
+	case goinsta.Error400:
+		return "bad_request"
+	case goinsta.Error503:
+		return "unavailable"
+	}
+	return "other"
+}
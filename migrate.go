@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// migration changes the database from version-1 to version, run returns the number of changed records
+type migration struct {
+	version int
+	name    string
+	run     func(tx *bolt.Tx) (int, error)
+}
+
+// migrations must be ordered by version, never change or remove the released ones
+var migrations = []migration{
+	{1, "create buckets", createBuckets("stats", "followed", "watching", "followqueue")},
+	{2, "stats per day, action and source", migrateStats},
+	{3, "relationship records in followed", migrateFollowed},
+	{4, "audit and sessions buckets", createBuckets("audit", "sessions")},
+	{5, "budget bucket", createBuckets("budget")},
+	{6, "cooldown bucket", createBuckets("cooldown")},
+	{7, "tags, comments and whitelist buckets seeded from config", seedLists},
+	{8, "tag settings bucket", createBuckets("tagsettings")},
+}
+
+var errDryRun = errors.New("dry run")
+
+// schemaVersion is the version the latest migration brings the database to
+func schemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func createBuckets(names ...string) func(tx *bolt.Tx) (int, error) {
+	return func(tx *bolt.Tx) (int, error) {
+		var created int
+		for _, name := range names {
+			if tx.Bucket([]byte(name)) != nil {
+				continue
+			}
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return created, errors.Wrapf(err, "failed to create '%s' bucket", name)
+			}
+			created++
+		}
+		return created, nil
+	}
+}
+
+// getSchemaVersion returns the version stored in the meta bucket, 0 for databases created before it
+func getSchemaVersion(tx *bolt.Tx) int {
+	bk := tx.Bucket([]byte("meta"))
+	if bk == nil {
+		return 0
+	}
+
+	value := bk.Get([]byte("version"))
+	if len(value) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(value))
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	bk, err := tx.CreateBucketIfNotExists([]byte("meta"))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get 'meta' bucket")
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(version))
+	if err := bk.Put([]byte("version"), value); err != nil {
+		return err
+	}
+	return bk.Put([]byte("migrated_at"), []byte(time.Now().Format(time.RFC3339)))
+}
+
+// migrate runs the pending migrations in a single transaction after saving a backup of the database.
+// With dryRun the changes are reported and rolled back.
+func migrate(db *bolt.DB, dryRun bool) error {
+	var version, buckets int
+	err := db.View(func(tx *bolt.Tx) error {
+		version = getSchemaVersion(tx)
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets++
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if version > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported %d", version, schemaVersion())
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		if dryRun {
+			logger.WithField("version", version).Info("database schema is up to date")
+		}
+		return nil
+	}
+
+	if buckets > 0 && !dryRun {
+		path := fmt.Sprintf("%s.v%d-%s.bak", db.Path(), version, time.Now().Format("20060102-150405"))
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(path, 0600)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to backup database before migration")
+		}
+		logger.WithField("path", path).Info("database backup saved")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, m := range pending {
+			changed, err := m.run(tx)
+			if err != nil {
+				return errors.Wrapf(err, "migration %d (%s) failed", m.version, m.name)
+			}
+			logger.WithFields(logrus.Fields{"version": m.version, "migration": m.name, "changes": changed}).Info("migration applied")
+
+			if err := setSchemaVersion(tx, m.version); err != nil {
+				return err
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		logger.WithFields(logrus.Fields{"from": version, "to": schemaVersion()}).Info("dry run: database schema would be migrated, nothing changed")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{"from": version, "to": schemaVersion()}).Info("database schema migrated")
+	return nil
+}
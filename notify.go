@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// severity of a notification, sinks only get the severities they are configured for
+type severity int
+
+const (
+	severityProgress severity = iota
+	severityInfo
+	severityWarning
+	severityFatal
+)
+
+var severityNames = []string{"progress", "info", "warning", "fatal"}
+
+func (s severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return "unknown"
+}
+
+func parseSeverity(name string) (severity, error) {
+	for i, severityName := range severityNames {
+		if strings.EqualFold(name, severityName) {
+			return severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q, should be one of: %s", name, strings.Join(severityNames, ", "))
+}
+
+// notification is a status message for admins
+type notification struct {
+	Severity severity
+	// Key groups progress reports of a task, so a sink can replace the previous one
+	Key  string
+	Text string
+	Time time.Time
+}
+
+// Notifier delivers notifications to a destination
+type Notifier interface {
+	Notify(n notification) error
+}
+
+// notifyQueueSize is how many notifications a sink can fall behind, newer ones are dropped
+const notifyQueueSize = 100
+
+// sink delivers its queue in background, so a slow destination doesn't stall the dispatcher and the tasks
+type sink struct {
+	name       string
+	notifier   Notifier
+	severities map[severity]bool
+	// timeout limits one delivery, the next notification doesn't wait for a sink which doesn't answer. 0 is unlimited.
+	timeout time.Duration
+	queue   chan notification
+}
+
+func startSink(name string, notifier Notifier, severities map[severity]bool, timeout time.Duration) sink {
+	s := sink{name: name, notifier: notifier, severities: severities, timeout: timeout, queue: make(chan notification, notifyQueueSize)}
+	go s.deliver()
+	return s
+}
+
+// deliver sends the queued notifications in order until the queue is closed
+func (s sink) deliver() {
+	for n := range s.queue {
+		done := make(chan error, 1)
+		go func(n notification) {
+			done <- s.notifier.Notify(n)
+		}(n)
+
+		var timeout <-chan time.Time
+		if s.timeout > 0 {
+			timeout = time.After(s.timeout)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				logger.WithField("sink", s.name).WithError(err).Error("notification failed")
+			}
+		case <-timeout:
+			// the delivery can't be aborted, it finishes in background
+			logger.WithFields(logrus.Fields{"sink": s.name, "timeout": s.timeout}).Error("notification timed out")
+		}
+	}
+}
+
+var (
+	sinksMu sync.RWMutex
+	sinks   []sink
+	// notifyBot is used by the telegram sink, until it is set only the other sinks work
+	notifyBot Messenger
+)
+
+// notify queues the text for every sink configured for sev without waiting for the delivery, failures are only logged
+func notify(sev severity, key, text string) {
+	n := notification{Severity: sev, Key: key, Text: text, Time: time.Now()}
+
+	// the queues are closed by configureNotifiers under the write lock
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+
+	if sinks == nil {
+		logger.WithField("severity", sev.String()).Info(text)
+		return
+	}
+
+	for _, s := range sinks {
+		if !s.severities[sev] {
+			continue
+		}
+		select {
+		case s.queue <- n:
+		default:
+			logger.WithFields(logrus.Fields{"sink": s.name, "severity": sev.String()}).Warn("notification queue is full, dropping")
+		}
+	}
+}
+
+// setNotifyBot enables the telegram sink
+func setNotifyBot(bot Messenger) {
+	sinksMu.Lock()
+	notifyBot = bot
+	sinksMu.Unlock()
+
+	configureNotifiers()
+}
+
+// configureNotifiers creates the sinks from the notify section of the config
+func configureNotifiers() {
+	viper.SetDefault("notify.telegram.enabled", true)
+	viper.SetDefault("notify.telegram.severities", severityNames)
+	viper.SetDefault("notify.stdout.enabled", true)
+	viper.SetDefault("notify.stdout.severities", severityNames)
+	viper.SetDefault("notify.webhook.severities", []string{"warning", "fatal"})
+	viper.SetDefault("notify.smtp.port", 587)
+	viper.SetDefault("notify.smtp.severities", []string{"fatal"})
+	for _, name := range []string{"telegram", "stdout", "webhook", "smtp"} {
+		viper.SetDefault("notify."+name+".timeout", "30s")
+	}
+
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	configured := make([]sink, 0)
+	add := func(name string, notifier Notifier) {
+		severities := make(map[severity]bool)
+		for _, severityName := range viper.GetStringSlice("notify." + name + ".severities") {
+			sev, err := parseSeverity(severityName)
+			if err != nil {
+				logger.WithField("sink", name).WithError(err).Warn("invalid severity")
+				continue
+			}
+			severities[sev] = true
+		}
+		configured = append(configured, startSink(name, notifier, severities, viper.GetDuration("notify."+name+".timeout")))
+	}
+
+	if notifyBot != nil && viper.GetBool("notify.telegram.enabled") {
+		add("telegram", &telegramSink{bot: notifyBot})
+	}
+	if viper.GetBool("notify.stdout.enabled") {
+		add("stdout", stdoutSink{})
+	}
+	if url := viper.GetString("notify.webhook.url"); url != "" {
+		add("webhook", &webhookSink{url: url, client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if host := viper.GetString("notify.smtp.host"); host != "" {
+		add("smtp", &smtpSink{
+			host:     host,
+			port:     viper.GetInt("notify.smtp.port"),
+			username: viper.GetString("notify.smtp.username"),
+			password: viper.GetString("notify.smtp.password"),
+			from:     viper.GetString("notify.smtp.from"),
+			to:       viper.GetStringSlice("notify.smtp.to"),
+		})
+	}
+
+	// the workers of the previous sinks deliver what is queued and stop
+	for _, s := range sinks {
+		close(s.queue)
+	}
+	sinks = configured
+}
+
+// telegramSink sends notifications to reportID, progress reports edit the messages tracked in editMessage
+type telegramSink struct {
+	bot Messenger
+}
+
+func (t *telegramSink) Notify(n notification) error {
+	if n.Severity == severityProgress {
+		return t.progress(n)
+	}
+
+	msg := tgbotapi.NewMessage(reportID, n.Text)
+	msg.DisableNotification = n.Severity < severityWarning
+	_, err := t.bot.Send(msg)
+	return err
+}
+
+// progress edits the messages tracked for n.Key, or sends a new report if there are none
+func (t *telegramSink) progress(n notification) error {
+	if n.Key == "" {
+		return nil
+	}
+
+	l.RLock()
+	rn := make(map[int]int, len(editMessage[n.Key]))
+	for UserID, EditID := range editMessage[n.Key] {
+		rn[UserID] = EditID
+	}
+	l.RUnlock()
+
+	if len(rn) > 0 {
+		for UserID, EditID := range rn {
+			t.bot.Edit(int64(UserID), EditID, n.Text)
+		}
+		return nil
+	}
+
+	msg := tgbotapi.NewMessage(reportID, n.Text)
+	msgRes, err := t.bot.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	if editMessage[n.Key] == nil {
+		editMessage[n.Key] = make(map[int]int)
+	}
+	editMessage[n.Key][int(reportID)] = msgRes.MessageID
+	l.Unlock()
+	return nil
+}
+
+// stdoutSink writes notifications to the log
+type stdoutSink struct{}
+
+func (stdoutSink) Notify(n notification) error {
+	if n.Key != "" {
+		logger.WithFields(logrus.Fields{"severity": n.Severity.String(), "task": n.Key}).Info(n.Text)
+	} else {
+		logger.WithField("severity", n.Severity.String()).Info(n.Text)
+	}
+	return nil
+}
+
+// webhookSink posts notifications as JSON
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (h *webhookSink) Notify(n notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"account":  instaUsername,
+		"severity": n.Severity.String(),
+		"key":      n.Key,
+		"text":     n.Text,
+		"time":     n.Time.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// smtpSink sends notifications by email
+type smtpSink struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (m *smtpSink) Notify(n notification) error {
+	if len(m.to) == 0 {
+		return fmt.Errorf("notify.smtp.to is empty")
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: go-instabot %s: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		m.from, strings.Join(m.to, ", "), instaUsername, n.Severity, n.Text)
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", m.host, m.port), auth, m.from, m.to, []byte(message))
+}
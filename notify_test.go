@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier records notifications, every delivery waits for delay
+type recordingNotifier struct {
+	mu    sync.Mutex
+	delay time.Duration
+	texts []string
+}
+
+func (r *recordingNotifier) Notify(n notification) error {
+	time.Sleep(r.delay)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.texts = append(r.texts, n.Text)
+	return nil
+}
+
+func (r *recordingNotifier) Texts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.texts...)
+}
+
+// useSinks replaces the configured sinks, the returned func restores them
+func useSinks(configured ...sink) func() {
+	sinksMu.Lock()
+	previous := sinks
+	sinks = configured
+	sinksMu.Unlock()
+
+	return func() {
+		sinksMu.Lock()
+		for _, s := range sinks {
+			close(s.queue)
+		}
+		sinks = previous
+		sinksMu.Unlock()
+	}
+}
+
+func waitForTexts(t *testing.T, r *recordingNotifier, count int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if texts := r.Texts(); len(texts) >= count {
+			return texts
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("got %v, want %d notifications", r.Texts(), count)
+	return nil
+}
+
+func TestNotifyDoesNotWaitForSlowSinks(t *testing.T) {
+	slow := &recordingNotifier{delay: 200 * time.Millisecond}
+	fast := &recordingNotifier{}
+	all := map[severity]bool{severityProgress: true, severityInfo: true}
+	defer useSinks(startSink("slow", slow, all, time.Minute), startSink("fast", fast, map[severity]bool{severityInfo: true}, time.Minute))()
+
+	start := time.Now()
+	notify(severityInfo, "", "one")
+	notify(severityProgress, "follow", "two")
+	notify(severityInfo, "", "three")
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("notify took %s", elapsed)
+	}
+
+	if texts := waitForTexts(t, fast, 2); texts[0] != "one" || texts[1] != "three" {
+		t.Errorf("fast sink got %v, want [one three]", texts)
+	}
+	if texts := waitForTexts(t, slow, 3); texts[0] != "one" || texts[1] != "two" || texts[2] != "three" {
+		t.Errorf("slow sink got %v, want [one two three]", texts)
+	}
+}
+
+func TestNotifyTimeout(t *testing.T) {
+	stuck := &recordingNotifier{delay: time.Second}
+	defer useSinks(startSink("stuck", stuck, map[severity]bool{severityInfo: true}, 50*time.Millisecond))()
+
+	start := time.Now()
+	notify(severityInfo, "", "one")
+	notify(severityInfo, "", "two")
+	// both deliveries start before the first one finishes, because the first one timed out
+	if texts := waitForTexts(t, stuck, 2); time.Since(start) > 1500*time.Millisecond {
+		t.Errorf("deliveries waited for each other: %v in %s", texts, time.Since(start))
+	}
+}
+
+func TestNotifyDropsWhenQueueIsFull(t *testing.T) {
+	blocked := &recordingNotifier{delay: time.Hour}
+	s := startSink("blocked", blocked, map[severity]bool{severityInfo: true}, 0)
+	defer useSinks(s)()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < notifyQueueSize*2; i++ {
+			notify(severityInfo, "", "text")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify blocked on a full queue")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ad/cron"
+	"github.com/sirupsen/logrus"
+)
+
+// jobConfig is an entry of the "schedule" section of config
+type jobConfig struct {
+	Spec    string
+	Enabled bool
+	Args    string
+}
+
+// scheduledJob is a cron job which can be reconfigured at runtime
+type scheduledJob struct {
+	name     string
+	defaults jobConfig
+	config   jobConfig
+	run      func(args string)
+	id       int
+}
+
+// scheduler keeps the cron entries in sync with the "schedule" section of config
+type scheduler struct {
+	mu   sync.Mutex
+	cron *cron.Cron
+	jobs map[string]*scheduledJob
+}
+
+// jobScheduler is used to re-apply the schedule when config changes
+var jobScheduler *scheduler
+
+func newScheduler(c *cron.Cron) *scheduler {
+	return &scheduler{cron: c, jobs: make(map[string]*scheduledJob)}
+}
+
+// Register adds a job with its default spec, it is scheduled on the next Apply
+func (s *scheduler) Register(name, spec, args string, run func(args string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defaults := jobConfig{Spec: spec, Enabled: true, Args: args}
+	s.jobs[name] = &scheduledJob{name: name, defaults: defaults, run: run}
+}
+
+// Apply schedules every registered job using config, jobs missing in config keep their defaults.
+// Invalid specs are reported and the job keeps its previous schedule.
+func (s *scheduler) Apply(config map[string]jobConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var problems []string
+	s.update(func() {
+		for name, job := range s.jobs {
+			jc, ok := config[name]
+			if !ok {
+				jc = job.defaults
+			}
+			if jc.Spec == "" {
+				jc.Spec = job.defaults.Spec
+			}
+			if err := s.schedule(job, jc); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			}
+		}
+	})
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid schedule: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// SetSpec changes the spec of a job
+func (s *scheduler) SetSpec(name, spec string) error {
+	return s.change(name, func(jc *jobConfig) { jc.Spec = spec })
+}
+
+// SetArgs changes the args passed to a job
+func (s *scheduler) SetArgs(name, args string) error {
+	return s.change(name, func(jc *jobConfig) { jc.Args = args })
+}
+
+// SetEnabled pauses or resumes a job
+func (s *scheduler) SetEnabled(name string, enabled bool) error {
+	return s.change(name, func(jc *jobConfig) { jc.Enabled = enabled })
+}
+
+func (s *scheduler) change(name string, fn func(jc *jobConfig)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("job %s not found", name)
+	}
+
+	jc := job.config
+	fn(&jc)
+
+	var err error
+	s.update(func() {
+		err = s.schedule(job, jc)
+	})
+	return err
+}
+
+// update stops cron while fn changes the entries, cron doesn't synchronize them while running
+func (s *scheduler) update(fn func()) {
+	s.cron.Stop()
+	defer s.cron.Start()
+
+	fn()
+}
+
+func (s *scheduler) schedule(job *scheduledJob, jc jobConfig) error {
+	if job.id <= 0 || jc.Spec != job.config.Spec || jc.Args != job.config.Args {
+		run := job.run
+		args := jc.Args
+		id, err := s.cron.AddFunc(jc.Spec, func() { run(args) })
+		if err != nil {
+			return err
+		}
+		if job.id > 0 {
+			s.cron.RemoveFunc(job.id)
+		}
+		job.id = id
+	}
+
+	if jc.Enabled {
+		s.cron.ResumeFunc(job.id)
+	} else {
+		s.cron.PauseFunc(job.id)
+	}
+	job.config = jc
+
+	logger.WithFields(logrus.Fields{"job": job.name, "spec": jc.Spec, "enabled": jc.Enabled}).Info("job scheduled")
+	return nil
+}
+
+// List describes every job with its state and next run time
+func (s *scheduler) List() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := make(map[int]string)
+	for _, entry := range s.cron.Entries() {
+		if !entry.Next.IsZero() {
+			next[entry.Id] = entry.Next.Format("2006-01-02 15:04")
+		}
+	}
+
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		job := s.jobs[name]
+		line := fmt.Sprintf("%s — %s, <code>%s</code>", name, getJobState(s.cron, job.id), job.config.Spec)
+		if job.config.Args != "" {
+			line += fmt.Sprintf(", args %s", job.config.Args)
+		}
+		if job.config.Enabled && next[job.id] != "" {
+			line += fmt.Sprintf(", next %s", next[job.id])
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No scheduled jobs"
+	}
+	return strings.Join(lines, "\n")
+}
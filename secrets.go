@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// secretEnv are the environment variables of the secrets in config, "<name>_FILE" variables hold paths to files with them
+var secretEnv = map[string]string{
+	"user.instagram.password":      "INSTABOT_INSTAGRAM_PASSWORD",
+	"user.instagram.proxy":         "INSTABOT_INSTAGRAM_PROXY",
+	"user.telegram.token":          "INSTABOT_TELEGRAM_TOKEN",
+	"user.telegram.proxy_user":     "INSTABOT_TELEGRAM_PROXY_USER",
+	"user.telegram.proxy_password": "INSTABOT_TELEGRAM_PROXY_PASSWORD",
+}
+
+// getSecret returns the secret from its environment variable, from the file of "<name>_FILE" or "<key>_file",
+// or from config. Secrets are only kept in variables, so changeConfig never writes them to config.
+func getSecret(v *viper.Viper, key string) string {
+	if value, ok := secretFromEnv(v, key); ok {
+		return value
+	}
+	return v.GetString(key)
+}
+
+// secretFromEnv returns the secret if it is set outside of config.json
+func secretFromEnv(v *viper.Viper, key string) (string, bool) {
+	name := secretEnv[key]
+	if value := os.Getenv(name); value != "" {
+		return value, true
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		path = v.GetString(key + "_file")
+	}
+	if path == "" {
+		return "", false
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.WithField("key", key).WithError(err).Error("can't read secret file")
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
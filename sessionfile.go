@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// sessionKeyEnv holds the key of the Instagram session file, it is used instead of the key file when set
+const sessionKeyEnv = "INSTABOT_SESSION_KEY"
+
+// plainSessionPath is where older versions exported the session without encryption
+const plainSessionPath = ".goinsta"
+
+// sessionMagic starts encrypted session files, the version allows to change the format later
+var sessionMagic = []byte("instabot-session-v1\n")
+
+// sessionKey returns the key from the environment or from user.instagram.session.key_file,
+// a random key file is created on first use
+func sessionKey() ([]byte, error) {
+	if secret := os.Getenv(sessionKeyEnv); secret != "" {
+		key := sha256.Sum256([]byte(secret))
+		return key[:], nil
+	}
+
+	path := viper.GetString("user.instagram.session.key_file")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		data = []byte(hex.EncodeToString(random))
+		if err := writePrivateFile(path, data); err != nil {
+			return nil, errors.Wrapf(err, "failed to create session key file '%s'", path)
+		}
+		logger.WithField("path", path).Info("session key file created")
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read session key file '%s'", path)
+	} else if err := restrictPermissions(path); err != nil {
+		return nil, err
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return nil, fmt.Errorf("session key file '%s' is empty", path)
+	}
+	key := sha256.Sum256([]byte(secret))
+	return key[:], nil
+}
+
+// exportInstaSession saves the session encrypted to user.instagram.session.path
+func exportInstaSession(session *goinsta.Instagram) error {
+	key, err := sessionKey()
+	if err != nil {
+		return err
+	}
+
+	var plain bytes.Buffer
+	if err := goinsta.Export(session, &plain); err != nil {
+		return errors.Wrapf(err, "failed to export session")
+	}
+
+	data, err := encryptSession(key, plain.Bytes())
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(viper.GetString("user.instagram.session.path"), data)
+}
+
+// importInstaSession loads the encrypted session, a plaintext .goinsta is encrypted and removed first
+func importInstaSession() (*goinsta.Instagram, error) {
+	path := viper.GetString("user.instagram.session.path")
+	if err := migratePlainSession(path); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := restrictPermissions(path); err != nil {
+		return nil, err
+	}
+
+	key, err := sessionKey()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptSession(key, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt session '%s'", path)
+	}
+	return goinsta.ImportReader(bytes.NewReader(plain))
+}
+
+// migratePlainSession encrypts the plaintext session of older versions if there is no encrypted one yet
+func migratePlainSession(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	plain, err := ioutil.ReadFile(plainSessionPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	key, err := sessionKey()
+	if err != nil {
+		return err
+	}
+	data, err := encryptSession(key, plain)
+	if err != nil {
+		return err
+	}
+	if err := writePrivateFile(path, data); err != nil {
+		return err
+	}
+	if err := os.Remove(plainSessionPath); err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{"from": plainSessionPath, "to": path}).Info("session file encrypted")
+	return nil
+}
+
+func encryptSession(key, plain []byte) ([]byte, error) {
+	gcm, err := sessionCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := append([]byte(nil), sessionMagic...)
+	data = append(data, nonce...)
+	return gcm.Seal(data, nonce, plain, sessionMagic), nil
+}
+
+func decryptSession(key, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, sessionMagic) {
+		return nil, fmt.Errorf("not an encrypted session file")
+	}
+	data = data[len(sessionMagic):]
+
+	gcm, err := sessionCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("session file is truncated")
+	}
+
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, sessionMagic)
+	if err != nil {
+		return nil, fmt.Errorf("wrong key or corrupted session file")
+	}
+	return plain, nil
+}
+
+func sessionCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writePrivateFile replaces path with data readable only by the owner
+func writePrivateFile(path string, data []byte) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// restrictPermissions makes a secret file readable only by the owner
+func restrictPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 == 0 {
+		return nil
+	}
+
+	logger.WithFields(logrus.Fields{"path": path, "mode": info.Mode().Perm().String()}).Warn("file is readable by others, restricting permissions")
+	return os.Chmod(path, 0600)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ahmdrz/goinsta/v2"
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// followSession is a checkpoint of a follow task, it lets the task continue after restart
+type followSession struct {
+	Task      string                    `json:"task"`
+	Arg       string                    `json:"arg,omitempty"`
+	StartedAt time.Time                 `json:"started_at"`
+	UpdatedAt time.Time                 `json:"updated_at"`
+	Remaining []string                  `json:"remaining"`
+	Current   int                       `json:"current"`
+	AllCount  int                       `json:"all_count"`
+	Report    map[string]map[string]int `json:"report,omitempty"`
+}
+
+func (s followSession) String() string {
+	name := s.Task
+	if s.Arg != "" {
+		name += " " + s.Arg
+	}
+	return fmt.Sprintf("%s — started %s, done %d/%d, %d left",
+		name, s.StartedAt.Format("2006-01-02 15:04"), s.Current, s.AllCount, len(s.Remaining))
+}
+
+// resumableTasks are the tasks which checkpoint their progress
+var resumableTasks = []string{"follow", "refollow", "followLikers"}
+
+// loadSession returns the saved session of task if it was started with /resume, otherwise a new one
+func loadSession(ctx context.Context, db *bolt.DB, task, arg string) (session *followSession, resumed bool) {
+	if isResumed(ctx) {
+		saved, err := getSession(db, task)
+		if err == nil {
+			taskLogger(task).WithField("started", saved.StartedAt).Info("resuming session")
+			return saved, true
+		}
+		taskLogger(task).WithError(err).Error("can't load session")
+	}
+	return &followSession{Task: task, Arg: arg, StartedAt: time.Now()}, false
+}
+
+// checkpoint saves the session, errors are only logged because the task can go on without it
+func checkpoint(db *bolt.DB, session *followSession) {
+	session.UpdatedAt = time.Now()
+	if err := saveSession(db, session); err != nil {
+		taskLogger(session.Task).WithError(err).Error("can't save session")
+	}
+}
+
+// withSession forgets the saved session of a task when it is finished or cancelled by an admin.
+// Sessions of failed tasks and tasks stopped on shutdown are kept to be resumed.
+func withSession(runner *taskRunner, db *bolt.DB, name string, fn taskFunc) taskFunc {
+	return func(ctx context.Context, arg string) error {
+		if !isResumed(ctx) {
+			if err := deleteSession(db, name); err != nil {
+				taskLogger(name).WithError(err).Error("can't delete session")
+			}
+		}
+
+		err := fn(ctx, arg)
+		if err == nil || (err == context.Canceled && !runner.Stopping()) {
+			if err := deleteSession(db, name); err != nil {
+				taskLogger(name).WithError(err).Error("can't delete session")
+			}
+		}
+		return err
+	}
+}
+
+func saveSession(db *bolt.DB, session *followSession) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode session '%s'", session.Task)
+	}
+	return updateDB(db, []byte("sessions"), []byte(session.Task), value)
+}
+
+func getSession(db *bolt.DB, task string) (*followSession, error) {
+	var session followSession
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("sessions"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'sessions' bucket")
+		}
+
+		value := bk.Get([]byte(task))
+		if value == nil {
+			return errors.Wrapf(fmt.Errorf("key not found"), "failed to find session for '%s'", task)
+		}
+
+		if err := json.Unmarshal(value, &session); err != nil {
+			return errors.Wrapf(err, "invalid session for '%s'", task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func getSessions(db *bolt.DB) ([]followSession, error) {
+	var sessions []followSession
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("sessions"))
+		if bk == nil {
+			return errors.Wrapf(fmt.Errorf("failed to find bucket"), "failed to get 'sessions' bucket")
+		}
+
+		return bk.ForEach(func(k, v []byte) error {
+			var session followSession
+			if err := json.Unmarshal(v, &session); err != nil {
+				taskLogger(string(k)).WithError(err).Warn("invalid session")
+				return nil
+			}
+			sessions = append(sessions, session)
+			return nil
+		})
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Task < sessions[j].Task })
+	return sessions, err
+}
+
+func deleteSession(db *bolt.DB, task string) error {
+	return deleteKeyFromBucket(db, "sessions", task)
+}
+
+// formatSessions describes the sessions which can be resumed
+func formatSessions(sessions []followSession) string {
+	if len(sessions) == 0 {
+		return "No unfinished sessions"
+	}
+
+	lines := make([]string, 0, len(sessions)+1)
+	for _, session := range sessions {
+		lines = append(lines, session.String())
+	}
+	lines = append(lines, "\n/resume task — continue")
+	return strings.Join(lines, "\n")
+}
+
+// notifySessions offers to resume the sessions left after the previous run
+func notifySessions(bot Messenger, db *bolt.DB) {
+	sessions, err := getSessions(db)
+	if err != nil {
+		logger.WithError(err).Error("can't get sessions")
+		return
+	}
+	if len(sessions) == 0 {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(reportID, "Unfinished sessions:\n"+formatSessions(sessions))
+	bot.Send(msg)
+}
+
+// resumeSession lists unfinished sessions or resumes the one of task from args
+func resumeSession(bot Messenger, db *bolt.DB, runner *taskRunner, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+
+	name := strings.TrimSpace(args)
+	if name == "" {
+		sessions, err := getSessions(db)
+		if err != nil {
+			msg.Text = err.Error()
+		} else {
+			msg.Text = formatSessions(sessions)
+		}
+		bot.Send(msg)
+		return
+	}
+
+	for _, task := range resumableTasks {
+		if strings.EqualFold(task, name) {
+			name = task
+		}
+	}
+
+	session, err := getSession(db, name)
+	if err != nil {
+		msg.Text = fmt.Sprintf("no session to resume for %s", name)
+		bot.Send(msg)
+		return
+	}
+
+	reportTaskStart(bot, name, runner.Resume(name, session.Arg), userID)
+}
+
+// sessionUsers restores the users left in session, skipping the ones followed before the restart
+func sessionUsers(db *bolt.DB, session *followSession) []goinsta.User {
+	users := make([]goinsta.User, 0, len(session.Remaining))
+	for _, username := range session.Remaining {
+		if previoslyFollowed, _ := getFollowed(db, username); previoslyFollowed != "" {
+			continue
+		}
+		users = append(users, goinsta.User{Username: username})
+	}
+	return users
+}
+
+func usernames(users []goinsta.User) []string {
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	return names
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// statsActions are shown first and in this order, other actions follow sorted by name
+var statsActions = []string{"follow", "like", "comment", "unfollow"}
+
+// maxStatsDays limits the range of /stats
+const maxStatsDays = 366
+
+// todayStats is the daily report sent by /stats without arguments
+func todayStats(db *bolt.DB) string {
+	message := `<i>%s</i>
+
+<b>Today stats</b>
+Unfollowed — %d
+Followed — %d
+	Followed by tags — %d
+	Refollowed — %d
+	Followed likers — %d
+	Followed from queue — %d
+Liked — %d
+Commented — %d`
+
+	today := time.Now()
+	days, err := getStatsRange(db, today, today)
+	if err != nil {
+		return err.Error()
+	}
+	stats := days[0]
+
+	return fmt.Sprintf(message,
+		getStatus(),
+		stats.Count("unfollow", ""),
+		stats.Count("follow", ""),
+		stats.Count("follow", "tag"),
+		stats.Count("follow", "refollow"),
+		stats.Count("follow", "followlikers"),
+		stats.Count("follow", "queue"),
+		stats.Count("like", ""),
+		stats.Count("comment", ""),
+	)
+}
+
+// parseStatsRange parses "7d", "month", "2006-01-02" or "2006-01-02..2006-01-31" relative to now
+func parseStatsRange(args string, now time.Time) (from, to time.Time, err error) {
+	args = strings.TrimSpace(strings.ToLower(args))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch {
+	case args == "today":
+		return today, today, nil
+	case args == "month":
+		return today.AddDate(0, 0, 1-today.Day()), today, nil
+	case strings.HasSuffix(args, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(args, "d"))
+		if err != nil || days <= 0 {
+			return from, to, fmt.Errorf("invalid number of days %q", args)
+		}
+		from, to = today.AddDate(0, 0, 1-days), today
+	case strings.Contains(args, ".."):
+		parts := strings.SplitN(args, "..", 2)
+		if from, err = time.ParseInLocation("2006-01-02", strings.TrimSpace(parts[0]), now.Location()); err != nil {
+			return from, to, fmt.Errorf("invalid date %q", parts[0])
+		}
+		if to, err = time.ParseInLocation("2006-01-02", strings.TrimSpace(parts[1]), now.Location()); err != nil {
+			return from, to, fmt.Errorf("invalid date %q", parts[1])
+		}
+	default:
+		if from, err = time.ParseInLocation("2006-01-02", args, now.Location()); err != nil {
+			return from, to, fmt.Errorf("invalid range %q", args)
+		}
+		to = from
+	}
+
+	if to.Before(from) {
+		return from, to, fmt.Errorf("range ends before it starts")
+	}
+	if to.Sub(from) >= maxStatsDays*24*time.Hour {
+		return from, to, fmt.Errorf("range is longer than %d days", maxStatsDays)
+	}
+	return from, to, nil
+}
+
+// formatStatsRange shows totals by action and source and a table by day.
+// A long table is split into messages under telegramMessageLimit, each with its own <pre> block.
+func formatStatsRange(days []dayStats) []string {
+	if len(days) == 0 {
+		return []string{"No stats"}
+	}
+
+	totals := make(map[string]map[string]int)
+	for _, day := range days {
+		for action, sources := range day.Counts {
+			if totals[action] == nil {
+				totals[action] = make(map[string]int)
+			}
+			for source, count := range sources {
+				totals[action][source] += count
+			}
+		}
+	}
+	actions := sortedStatsActions(totals)
+
+	first, last := days[0].Day.Format("2006-01-02"), days[len(days)-1].Day.Format("2006-01-02")
+	text := fmt.Sprintf("<b>Stats %s..%s</b>\n", first, last)
+	if first == last {
+		text = fmt.Sprintf("<b>Stats %s</b>\n", first)
+	}
+
+	if len(actions) == 0 {
+		return []string{text + "No actions"}
+	}
+
+	for _, action := range actions {
+		sources := make([]string, 0, len(totals[action]))
+		var total int
+		for source, count := range totals[action] {
+			sources = append(sources, fmt.Sprintf("%s %d", source, count))
+			total += count
+		}
+		sort.Strings(sources)
+		text += fmt.Sprintf("%s — %d (%s)\n", action, total, strings.Join(sources, ", "))
+	}
+
+	if len(days) == 1 {
+		return []string{text}
+	}
+
+	header := fmt.Sprintf("%-5s", "day")
+	for _, action := range actions {
+		header += fmt.Sprintf(" %8.8s", action)
+	}
+	rows := make([]string, 0, len(days))
+	for _, day := range days {
+		row := day.Day.Format("01-02")
+		for _, action := range actions {
+			row += fmt.Sprintf(" %8d", day.Count(action, ""))
+		}
+		rows = append(rows, row)
+	}
+
+	texts := []string{text}
+	for _, table := range splitMessage(rows, telegramMessageLimit-len("<pre>\n</pre>")-len(header)) {
+		table = "<pre>" + header + "\n" + table + "</pre>"
+		if last := len(texts) - 1; len(texts[last])+1+len(table) <= telegramMessageLimit {
+			texts[last] += "\n" + table
+		} else {
+			texts = append(texts, table)
+		}
+	}
+	return texts
+}
+
+func sortedStatsActions(totals map[string]map[string]int) []string {
+	var actions []string
+	for _, action := range statsActions {
+		if _, ok := totals[action]; ok {
+			actions = append(actions, action)
+		}
+	}
+
+	var other []string
+	for action := range totals {
+		if !stringInStringSlice(action, statsActions) {
+			other = append(other, action)
+		}
+	}
+	sort.Strings(other)
+	return append(actions, other...)
+}
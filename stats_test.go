@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatStatsRangeSplitsLongTables(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	var days []dayStats
+	for day := 0; day < maxStatsDays; day++ {
+		days = append(days, dayStats{
+			Day:    from.AddDate(0, 0, day),
+			Counts: map[string]map[string]int{"follow": {"tag": 100}, "like": {"tag": 300}, "unfollow": {"": 50}},
+		})
+	}
+
+	texts := formatStatsRange(days)
+	if len(texts) < 2 {
+		t.Fatalf("got %d message, want the table split", len(texts))
+	}
+	rows := 0
+	for i, text := range texts {
+		if len(text) > telegramMessageLimit {
+			t.Errorf("message %d has %d bytes", i, len(text))
+		}
+		if strings.Count(text, "<pre>") != strings.Count(text, "</pre>") {
+			t.Errorf("message %d has an unclosed <pre>", i)
+		}
+		rows += strings.Count(text, "\n12-") + strings.Count(text, "\n01-")
+	}
+	if !strings.Contains(texts[0], "follow — 36600") {
+		t.Errorf("totals are missing from the first message: %s", texts[0])
+	}
+	if rows != 62 {
+		t.Errorf("found %d rows of January and December, want 62", rows)
+	}
+}
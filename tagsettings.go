@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// tagSettings overrides the limits of the "limits" section for a tag, nil fields use the config
+type tagSettings struct {
+	Follow       *int     `json:"follow,omitempty"`
+	Like         *int     `json:"like,omitempty"`
+	Comment      *int     `json:"comment,omitempty"`
+	LikeMin      *int     `json:"like_min,omitempty"`
+	LikeMax      *int     `json:"like_max,omitempty"`
+	CommentMin   *int     `json:"comment_min,omitempty"`
+	CommentMax   *int     `json:"comment_max,omitempty"`
+	PotencyRatio *float64 `json:"potency_ratio,omitempty"`
+	// Weight scales the counts taken from the config and makes a tag come earlier in /follow, 0 skips the tag, the default is 1
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+// tagSettingNames are the settings which can be changed by /settag
+var tagSettingNames = []string{"follow", "like", "comment", "like.min", "like.max", "comment.min", "comment.max", "potency_ratio", "weight"}
+
+// tagLimits are the limits used for a tag
+type tagLimits struct {
+	Like         limitRange
+	Comment      limitRange
+	FollowCount  int
+	PotencyRatio float64
+	Weight       float64
+}
+
+func (s *tagSettings) ints() map[string]**int {
+	return map[string]**int{
+		"follow":      &s.Follow,
+		"like":        &s.Like,
+		"comment":     &s.Comment,
+		"like.min":    &s.LikeMin,
+		"like.max":    &s.LikeMax,
+		"comment.min": &s.CommentMin,
+		"comment.max": &s.CommentMax,
+	}
+}
+
+// empty reports whether the tag uses the config for everything
+func (s tagSettings) empty() bool {
+	return s == tagSettings{}
+}
+
+// limits returns the limits of the tag, the settings which are not set come from the config.
+// Counts set for the tag are used as they are, the ones of the config are scaled by the weight.
+func (s tagSettings) limits() tagLimits {
+	weight := 1.0
+	if s.Weight != nil {
+		weight = *s.Weight
+	}
+	scale := func(count int) int {
+		return int(math.Round(float64(count) * weight))
+	}
+
+	limits := tagLimits{
+		Like:         limitRange{Min: likeLowerLimit, Max: likeUpperLimit, Count: scale(likeCount)},
+		Comment:      limitRange{Min: commentLowerLimit, Max: commentUpperLimit, Count: scale(commentCount)},
+		FollowCount:  scale(followCount),
+		PotencyRatio: potencyRatio,
+		Weight:       weight,
+	}
+
+	override := func(value *int, limit *int) {
+		if value != nil {
+			*limit = *value
+		}
+	}
+	override(s.Follow, &limits.FollowCount)
+	override(s.Like, &limits.Like.Count)
+	override(s.Comment, &limits.Comment.Count)
+	override(s.LikeMin, &limits.Like.Min)
+	override(s.LikeMax, &limits.Like.Max)
+	override(s.CommentMin, &limits.Comment.Min)
+	override(s.CommentMax, &limits.Comment.Max)
+	if s.PotencyRatio != nil {
+		limits.PotencyRatio = *s.PotencyRatio
+	}
+	return limits
+}
+
+// set changes a setting from tagSettingNames, "-" resets it to the config
+func (s *tagSettings) set(name, value string) error {
+	if value == "-" {
+		switch name {
+		case "potency_ratio":
+			s.PotencyRatio = nil
+		case "weight":
+			s.Weight = nil
+		default:
+			field, ok := s.ints()[name]
+			if !ok {
+				return fmt.Errorf("setting maybe one of: %s", strings.Join(tagSettingNames, ", "))
+			}
+			*field = nil
+		}
+		return nil
+	}
+
+	switch name {
+	case "potency_ratio":
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < -100 || ratio > 100 {
+			return fmt.Errorf("potency_ratio should be equal or greater than -100 and less or equal than 100")
+		}
+		s.PotencyRatio = &ratio
+	case "weight":
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 || weight > 100 {
+			return fmt.Errorf("weight should be equal or greater than 0 and less or equal than 100")
+		}
+		s.Weight = &weight
+	default:
+		field, ok := s.ints()[name]
+		if !ok {
+			return fmt.Errorf("setting maybe one of: %s", strings.Join(tagSettingNames, ", "))
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 || count > 10000 {
+			return fmt.Errorf("%s should be equal or greater than 0 and less or equal than 10000", name)
+		}
+		*field = &count
+	}
+	return nil
+}
+
+// validate checks the limits the tag gets with the current config
+func (s tagSettings) validate() error {
+	limits := s.limits()
+	if limits.Like.Max < limits.Like.Min {
+		return fmt.Errorf("like.max (%d) should not be less than like.min (%d)", limits.Like.Max, limits.Like.Min)
+	}
+	if limits.Comment.Max < limits.Comment.Min {
+		return fmt.Errorf("comment.max (%d) should not be less than comment.min (%d)", limits.Comment.Max, limits.Comment.Min)
+	}
+	return nil
+}
+
+func (s tagSettings) String() string {
+	limits := s.limits()
+	mark := func(set bool) string {
+		if set {
+			return "*"
+		}
+		return ""
+	}
+
+	return fmt.Sprintf("follow %d%s, like %d%s, comment %d%s, likes %d%s–%d%s, comments %d%s–%d%s, ratio %.2f%s, weight %g%s",
+		limits.FollowCount, mark(s.Follow != nil),
+		limits.Like.Count, mark(s.Like != nil),
+		limits.Comment.Count, mark(s.Comment != nil),
+		limits.Like.Min, mark(s.LikeMin != nil), limits.Like.Max, mark(s.LikeMax != nil),
+		limits.Comment.Min, mark(s.CommentMin != nil), limits.Comment.Max, mark(s.CommentMax != nil),
+		limits.PotencyRatio, mark(s.PotencyRatio != nil),
+		limits.Weight, mark(s.Weight != nil))
+}
+
+// getTagSettings returns the settings of every tag which has them
+func getTagSettings(db *bolt.DB) (map[string]tagSettings, error) {
+	settings := make(map[string]tagSettings)
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("tagsettings"))
+		if bk == nil {
+			return nil
+		}
+
+		return bk.ForEach(func(k, v []byte) error {
+			var s tagSettings
+			if err := json.Unmarshal(v, &s); err != nil {
+				return errors.Wrapf(err, "invalid settings of tag '%s'", k)
+			}
+			settings[string(k)] = s
+			return nil
+		})
+	})
+	return settings, err
+}
+
+// putTagSettings saves the settings of a tag, empty settings are removed
+func putTagSettings(db *bolt.DB, tag string, s tagSettings) error {
+	if s.empty() {
+		return deleteKeyFromBucket(db, "tagsettings", tag)
+	}
+
+	value, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode settings of tag '%s'", tag)
+	}
+	return updateDB(db, []byte("tagsettings"), []byte(tag), value)
+}
+
+// weightedOrder orders tags randomly, a tag with a bigger weight tends to come earlier
+// and so still runs when the budget is used up before the last tags. Tags with weight 0 are dropped.
+func weightedOrder(tags []string, settings map[string]tagSettings) []string {
+	keys := make(map[string]float64, len(tags))
+	ordered := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		weight := settings[tag].limits().Weight
+		if weight <= 0 {
+			continue
+		}
+		// Efraimidis-Spirakis sampling: the order by u^(1/weight) is a weighted sample without replacement
+		keys[tag] = math.Pow(rand.Float64(), 1/weight)
+		ordered = append(ordered, tag)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool { return keys[ordered[i]] > keys[ordered[j]] })
+	return ordered
+}
+
+// formatTags shows the tags with their limits, the settings of a tag are marked with *
+func formatTags(db *bolt.DB, tags []string) (string, error) {
+	settings, err := getTagSettings(db)
+	if err != nil {
+		return "", err
+	}
+
+	lines := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		lines = append(lines, fmt.Sprintf("#%s: %s", tag, settings[tag]))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// updateTagSettings handles /settag tag name=value..., "-" resets a setting and "reset" resets all of them
+func updateTagSettings(bot Messenger, db *bolt.DB, args string, userID int64) {
+	msg := tgbotapi.NewMessage(userID, "")
+	usage := "/settag tag name=value [name=value...] | /settag tag reset\nname maybe one of: " + strings.Join(tagSettingNames, ", ") + "\nvalue - uses the config"
+
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		msg.Text = usage
+		bot.Send(msg)
+		return
+	}
+	tag := strings.TrimPrefix(fields[0], "#")
+
+	if !inList(db, "tags", tag) {
+		msg.Text = fmt.Sprintf("#%s is not in tags, /addtags %s", tag, tag)
+		bot.Send(msg)
+		return
+	}
+
+	all, err := getTagSettings(db)
+	if err != nil {
+		msg.Text = err.Error()
+		bot.Send(msg)
+		return
+	}
+	settings := all[tag]
+
+	if len(fields) == 2 && fields[1] == "reset" {
+		settings = tagSettings{}
+	} else {
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				err = fmt.Errorf("%q should be name=value", field)
+			} else {
+				err = settings.set(parts[0], parts[1])
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = settings.validate()
+		}
+	}
+	if err == nil {
+		err = putTagSettings(db, tag, settings)
+	}
+
+	if err != nil {
+		msg.Text = fmt.Sprintf("%s\n\n%s", err, usage)
+	} else {
+		msg.Text = fmt.Sprintf("#%s: %s", tag, settings)
+	}
+	bot.Send(msg)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestTagLimitsScaleByWeight(t *testing.T) {
+	setTestLimits()
+	followCount, likeCount, commentCount = 10, 20, 3
+
+	weight, follow := 1.5, 4
+	limits := tagSettings{Weight: &weight, Follow: &follow}.limits()
+	if limits.FollowCount != 4 {
+		t.Errorf("follow = %d, the count set for the tag should not be scaled", limits.FollowCount)
+	}
+	if limits.Like.Count != 30 || limits.Comment.Count != 5 {
+		t.Errorf("like %d, comment %d, want 30 and 5", limits.Like.Count, limits.Comment.Count)
+	}
+	if limits.Like.Min != likeLowerLimit || limits.Like.Max != likeUpperLimit {
+		t.Errorf("like thresholds %d–%d are scaled", limits.Like.Min, limits.Like.Max)
+	}
+
+	if limits := (tagSettings{}).limits(); limits.FollowCount != 10 || limits.Weight != 1 {
+		t.Errorf("default: follow %d, weight %g, want 10 and 1", limits.FollowCount, limits.Weight)
+	}
+}
+
+func TestWeightedOrderDropsZeroWeight(t *testing.T) {
+	zero, heavy := 0.0, 1000.0
+	settings := map[string]tagSettings{"skipped": {Weight: &zero}, "heavy": {Weight: &heavy}}
+
+	first := 0
+	for i := 0; i < 100; i++ {
+		order := weightedOrder([]string{"light", "skipped", "heavy"}, settings)
+		if len(order) != 2 {
+			t.Fatalf("order %v, want light and heavy", order)
+		}
+		if order[0] == "heavy" {
+			first++
+		}
+	}
+	if first < 90 {
+		t.Errorf("heavy tag came first %d times of 100", first)
+	}
+}
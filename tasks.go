@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+// taskState is the lifecycle state of a registered task
+type taskState int
+
+const (
+	taskIdle taskState = iota
+	taskRunning
+	taskCancelling
+	taskFailed
+)
+
+func (s taskState) String() string {
+	switch s {
+	case taskIdle:
+		return "idle"
+	case taskRunning:
+		return "running"
+	case taskCancelling:
+		return "cancelling"
+	case taskFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+var (
+	errTaskRunning  = errors.New("task already running")
+	errTaskNotFound = errors.New("task not found")
+)
+
+// taskFunc is the body of a task, it must return soon after ctx is cancelled
+type taskFunc func(ctx context.Context, arg string) error
+
+type task struct {
+	name string
+	fn   taskFunc
+	flag *abool.AtomicBool
+
+	state     taskState
+	startedAt time.Time
+	lastError error
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// taskStatus is a snapshot of a task state
+type taskStatus struct {
+	Name      string
+	State     taskState
+	StartedAt time.Time
+	LastError error
+}
+
+// taskRunner runs named tasks, at most one instance of each at a time
+type taskRunner struct {
+	mu       sync.Mutex
+	tasks    map[string]*task
+	stopping bool
+}
+
+type resumeKey struct{}
+
+// isResumed reports whether the task was started by taskRunner.Resume
+func isResumed(ctx context.Context) bool {
+	resumed, _ := ctx.Value(resumeKey{}).(bool)
+	return resumed
+}
+
+func newTaskRunner() *taskRunner {
+	return &taskRunner{tasks: make(map[string]*task)}
+}
+
+// Register adds a task, flag (if any) is kept set while the task is running
+func (r *taskRunner) Register(name string, flag *abool.AtomicBool, fn taskFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tasks[name] = &task{name: name, fn: fn, flag: flag}
+}
+
+// Start runs the task in background with arg
+func (r *taskRunner) Start(name, arg string) error {
+	return r.start(name, arg, false)
+}
+
+// Resume runs the task in background asking it to continue the saved session
+func (r *taskRunner) Resume(name, arg string) error {
+	return r.start(name, arg, true)
+}
+
+func (r *taskRunner) start(name, arg string, resume bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tasks[name]
+	if !ok {
+		return errTaskNotFound
+	}
+	if t.state == taskRunning || t.state == taskCancelling {
+		return errTaskRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), resumeKey{}, resume))
+	t.state = taskRunning
+	t.startedAt = time.Now()
+	t.lastError = nil
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	if t.flag != nil {
+		t.flag.Set()
+	}
+
+	go r.run(ctx, t, arg)
+
+	return nil
+}
+
+func (r *taskRunner) run(ctx context.Context, t *task, arg string) {
+	var err error
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+		t.cancel()
+
+		r.mu.Lock()
+		if err != nil && err != context.Canceled {
+			taskLogger(t.name).WithError(err).Error("task failed")
+			t.state = taskFailed
+			t.lastError = err
+		} else {
+			t.state = taskIdle
+		}
+		if t.flag != nil {
+			t.flag.UnSet()
+		}
+		close(t.done)
+		r.mu.Unlock()
+	}()
+
+	taskLogger(t.name).Info("task started")
+	err = t.fn(ctx, arg)
+}
+
+// Cancel asks the task to stop, returns false if it is not running
+func (r *taskRunner) Cancel(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tasks[name]
+	if !ok || t.state != taskRunning {
+		return false
+	}
+	t.state = taskCancelling
+	t.cancel()
+	return true
+}
+
+// CancelAll stops every running task and waits until they return, used on shutdown
+func (r *taskRunner) CancelAll() {
+	r.mu.Lock()
+	r.stopping = true
+	r.mu.Unlock()
+
+	for _, status := range r.List() {
+		if r.Cancel(status.Name) {
+			r.Wait(status.Name)
+		}
+	}
+}
+
+// Stopping reports whether the runner is shutting down
+func (r *taskRunner) Stopping() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stopping
+}
+
+// Wait blocks until the task is not running
+func (r *taskRunner) Wait(name string) {
+	r.mu.Lock()
+	t, ok := r.tasks[name]
+	if !ok || t.done == nil {
+		r.mu.Unlock()
+		return
+	}
+	done := t.done
+	r.mu.Unlock()
+
+	<-done
+}
+
+// IsRunning reports whether the task is running or being cancelled
+func (r *taskRunner) IsRunning(name string) bool {
+	status, err := r.Status(name)
+	return err == nil && (status.State == taskRunning || status.State == taskCancelling)
+}
+
+// Status returns the current state of the task
+func (r *taskRunner) Status(name string) (taskStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tasks[name]
+	if !ok {
+		return taskStatus{}, errTaskNotFound
+	}
+	return taskStatus{Name: t.name, State: t.state, StartedAt: t.startedAt, LastError: t.lastError}, nil
+}
+
+// List returns the state of all tasks sorted by name
+func (r *taskRunner) List() []taskStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]taskStatus, 0, len(r.tasks))
+	for _, t := range r.tasks {
+		list = append(list, taskStatus{Name: t.name, State: t.state, StartedAt: t.startedAt, LastError: t.lastError})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// sleep pauses for d, returns ctx.Err() if ctx was cancelled earlier
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func formatTasks(list []taskStatus) string {
+	lines := make([]string, 0, len(list))
+	for _, status := range list {
+		line := fmt.Sprintf("%s — %s", status.Name, status.State)
+		switch status.State {
+		case taskRunning, taskCancelling:
+			line += fmt.Sprintf(" since %s (%s)", status.StartedAt.Format("15:04:05"), time.Since(status.StartedAt).Round(time.Second))
+		case taskFailed:
+			line += fmt.Sprintf(": %s", status.LastError)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No tasks"
+	}
+	return strings.Join(lines, "\n")
+}
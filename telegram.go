@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/proxy"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Messenger is the transport used to talk with admins
+type Messenger interface {
+	// Send sends a new message and returns it, the message ID can be used for editing later
+	Send(msg tgbotapi.MessageConfig) (tgbotapi.Message, error)
+	// Edit replaces the text of a previously sent message
+	Edit(chatID int64, messageID int, text string) error
+	// SendDocument uploads data as a file named name
+	SendDocument(chatID int64, name string, data []byte) error
+	// Updates returns the channel of incoming updates
+	Updates() (<-chan tgbotapi.Update, error)
+}
+
+// telegramBot is Messenger backed by the Telegram Bot API
+type telegramBot struct {
+	api *tgbotapi.BotAPI
+}
+
+// newTelegramBot authorizes the bot, using the SOCKS5 proxy from config if set
+func newTelegramBot(token string) (*telegramBot, error) {
+	var tr http.Transport
+
+	if telegramProxy != "" {
+		tr = http.Transport{
+			DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+				socksDialer, err := proxy.SOCKS5(
+					"tcp",
+					fmt.Sprintf("%s:%d", telegramProxy, telegramProxyPort),
+					&proxy.Auth{User: telegramProxyUser, Password: telegramProxyPassword},
+					proxy.Direct,
+				)
+				if err != nil {
+					logger.WithError(err).Error("can't connect to Telegram proxy")
+					return nil, err
+				}
+
+				return socksDialer.Dial(network, addr)
+			},
+		}
+	}
+
+	api, err := tgbotapi.NewBotAPIWithClient(token, &http.Client{
+		Transport: &tr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	api.Debug = false
+	logger.WithField("username", api.Self.UserName).Info("authorized on Telegram")
+
+	return &telegramBot{api: api}, nil
+}
+
+func (t *telegramBot) Send(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	message, err := t.api.Send(msg)
+	if err != nil {
+		telegramFailuresTotal.WithLabelValues("send").Inc()
+	}
+	return message, err
+}
+
+func (t *telegramBot) Edit(chatID int64, messageID int, text string) error {
+	edit := tgbotapi.EditMessageTextConfig{
+		BaseEdit: tgbotapi.BaseEdit{
+			ChatID:    chatID,
+			MessageID: messageID,
+		},
+		Text: text,
+	}
+	_, err := t.api.Send(edit)
+	if err != nil {
+		telegramFailuresTotal.WithLabelValues("edit").Inc()
+	}
+	return err
+}
+
+func (t *telegramBot) SendDocument(chatID int64, name string, data []byte) error {
+	doc := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+	_, err := t.api.Send(doc)
+	if err != nil {
+		telegramFailuresTotal.WithLabelValues("document").Inc()
+	}
+	return err
+}
+
+func (t *telegramBot) Updates() (<-chan tgbotapi.Update, error) {
+	var ucfg = tgbotapi.NewUpdate(0)
+	ucfg.Timeout = 60
+
+	updates, err := t.api.GetUpdatesChan(ucfg)
+	if err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// logMessenger only logs the messages, it is used when Telegram is unavailable and the bot is driven by the API
+type logMessenger struct{}
+
+func (logMessenger) Send(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	logger.WithField("chat", msg.ChatID).Info(msg.Text)
+	return tgbotapi.Message{}, nil
+}
+
+func (logMessenger) Edit(chatID int64, messageID int, text string) error {
+	logger.WithField("chat", chatID).Info(text)
+	return nil
+}
+
+func (logMessenger) SendDocument(chatID int64, name string, data []byte) error {
+	logger.WithFields(logrus.Fields{"chat": chatID, "document": name}).Info("document is not sent")
+	return nil
+}
+
+// Updates returns a channel without updates
+func (logMessenger) Updates() (<-chan tgbotapi.Update, error) {
+	return make(chan tgbotapi.Update), nil
+}
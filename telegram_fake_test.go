@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// fakeMessenger records everything sent through it instead of talking to Telegram.
+// Push updates with Receive to drive the dispatcher.
+type fakeMessenger struct {
+	mu sync.Mutex
+
+	updates chan tgbotapi.Update
+	lastID  int
+
+	// Sent holds all sent messages in order
+	Sent []tgbotapi.MessageConfig
+	// Edits holds all edits in order
+	Edits []fakeEdit
+	// Documents holds all uploaded documents in order
+	Documents []fakeDocument
+	// Err is returned by Send and Edit when set
+	Err error
+}
+
+type fakeEdit struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+}
+
+type fakeDocument struct {
+	ChatID int64
+	Name   string
+	Data   []byte
+}
+
+func newFakeMessenger() *fakeMessenger {
+	return &fakeMessenger{updates: make(chan tgbotapi.Update, 100)}
+}
+
+func (f *fakeMessenger) Send(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return tgbotapi.Message{}, f.Err
+	}
+	f.lastID++
+	f.Sent = append(f.Sent, msg)
+	return tgbotapi.Message{MessageID: f.lastID, Chat: &tgbotapi.Chat{ID: msg.ChatID}, Text: msg.Text}, nil
+}
+
+func (f *fakeMessenger) Edit(chatID int64, messageID int, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Edits = append(f.Edits, fakeEdit{ChatID: chatID, MessageID: messageID, Text: text})
+	return nil
+}
+
+func (f *fakeMessenger) SendDocument(chatID int64, name string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+	f.Documents = append(f.Documents, fakeDocument{ChatID: chatID, Name: name, Data: data})
+	return nil
+}
+
+func (f *fakeMessenger) Updates() (<-chan tgbotapi.Update, error) {
+	return f.updates, nil
+}
+
+// Receive queues a text message from userID as if it came from Telegram
+func (f *fakeMessenger) Receive(userID int, text string) tgbotapi.Update {
+	update := fakeUpdate(userID, text)
+	f.updates <- update
+	return update
+}
+
+// Texts returns the texts of all sent messages
+func (f *fakeMessenger) Texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	texts := make([]string, 0, len(f.Sent))
+	for _, msg := range f.Sent {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+// fakeUpdate builds an update with a text message, commands get the bot_command entity like Telegram does
+func fakeUpdate(userID int, text string) tgbotapi.Update {
+	message := &tgbotapi.Message{
+		From: &tgbotapi.User{ID: userID},
+		Chat: &tgbotapi.Chat{ID: int64(userID)},
+		Text: text,
+	}
+	if len(text) > 0 && text[0] == '/' {
+		length := len(text)
+		for i, c := range text {
+			if c == ' ' {
+				length = i
+				break
+			}
+		}
+		message.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+	return tgbotapi.Update{Message: message}
+}
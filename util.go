@@ -4,26 +4,30 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"io"
-	"log"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/spf13/viper"
 
 	"github.com/ahmdrz/goinsta/v2"
-
-	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
 // Whether we are in development mode or not
 var dev *bool
 var configFile *string
 
+// Whether to write logs to a file in log.dir
+var logToFile *bool
+
+// Whether to only report pending database migrations
+var migrateDryRun *bool
+
 // An image will be liked if the poster has more followers than likeLowerLimit, and less than likeUpperLimit
 var likeLowerLimit int
 var likeUpperLimit int
@@ -44,18 +48,6 @@ var commentCount int
 
 var maxLikesToAccountPerSession int
 
-// Hashtags list. Do not put the '#' in the config file
-var tagsList []string
-
-// Limits for the current hashtag
-var limits map[string]int
-
-// Comments list
-var commentsList []string
-
-// White list. Do not put the '@' in the config file
-var whiteList []string
-
 // Report that will be sent at the end of the script
 var report map[string]map[string]int
 
@@ -64,10 +56,10 @@ var numFollowed int
 var numLiked int
 var numCommented int
 
-// check will log.Fatal if err is an error
+// check logs err if it is an error, the bot keeps running
 func check(err error) {
 	if err != nil {
-		log.Println("ERROR:", err)
+		logger.Error(err)
 	}
 }
 
@@ -75,92 +67,68 @@ func check(err error) {
 func parseOptions() {
 	dev = flag.Bool("dev", false, "Use this option to use the script in development mode : nothing will be done for real")
 	configFile = flag.String("config", "config/config.json", "Path to config file")
-	logs := flag.Bool("logs", false, "Use this option to enable the logfile")
+	logToFile = flag.Bool("logs", false, "Use this option to write logs to log.dir too")
+	migrateDryRun = flag.Bool("migrate-dry-run", false, "Report pending database migrations without applying them and exit")
 
 	flag.Parse()
-
-	// -logs enables the log file
-	if *logs {
-		// Opens a log file
-		t := time.Now()
-		logFile, err := os.OpenFile("instabot-"+t.Format("2006-01-02-15-04-05")+".log", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
-		check(err)
-		defer logFile.Close()
-
-		// Duplicates the writer to stdout and logFile
-		mw := io.MultiWriter(os.Stdout, logFile)
-		log.SetOutput(mw)
-	}
 }
 
-// Gets the conf in the config file
+// Gets the conf in the config file, the bot stops with every problem of an invalid config
 func getConfig() {
 	viper.SetConfigFile(*configFile)
 
 	// Reads the config file
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
+	data, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		logger.WithError(err).Fatal("error reading config file")
 	}
 
 	// Confirms which config file is used
-	log.Printf("Using config: %s\n\n", viper.ConfigFileUsed())
-
-	likeLowerLimit = viper.GetInt("limits.like.min")
-	likeUpperLimit = viper.GetInt("limits.like.max")
-	likeCount = viper.GetInt("limits.like.count")
+	logger.WithField("file", viper.ConfigFileUsed()).Info("using config")
 
-	// followLowerLimit = viper.GetInt("limits.follow.min")
-	// followUpperLimit = viper.GetInt("limits.follow.max")
-	followCount = viper.GetInt("limits.follow.count")
-	potencyRatio = viper.GetFloat64("limits.follow.potency_ratio")
-
-	commentLowerLimit = viper.GetInt("limits.comment.min")
-	commentUpperLimit = viper.GetInt("limits.comment.max")
-	commentCount = viper.GetInt("limits.comment.count")
-
-	viper.SetDefault("limits.max_likes_to_account_per_session", 10)
-	maxLikesToAccountPerSession = viper.GetInt("limits.max_likes_to_account_per_session")
-
-	tagsList = viper.GetStringSlice("tags")
-
-	commentsList = viper.GetStringSlice("comments")
-
-	whiteList = viper.GetStringSlice("whitelist")
-
-	reportID = viper.GetInt64("user.telegram.reportID")
-	admins = viper.GetStringSlice("user.telegram.admins")
-
-	telegramToken = viper.GetString("user.telegram.token")
-	telegramProxy = viper.GetString("user.telegram.proxy")
-	telegramProxyPort = viper.GetInt32("user.telegram.proxy_port")
-	telegramProxyUser = viper.GetString("user.telegram.proxy_user")
-	telegramProxyPassword = viper.GetString("user.telegram.proxy_password")
-
-	instaUsername = viper.GetString("user.instagram.username")
-	instaPassword = viper.GetString("user.instagram.password")
-	instaProxy = viper.GetString("user.instagram.proxy")
+	setConfigDefaults(viper.GetViper())
+	c, err := parseConfig(data)
+	if problems, ok := err.(configErrors); ok {
+		for _, problem := range problems {
+			logger.WithField("file", viper.ConfigFileUsed()).Error(problem)
+		}
+		logger.Fatalf("invalid config, %d problems", len(problems))
+	}
+	if err == nil {
+		err = useConfig(data, c)
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("error reading config file")
+	}
 
 	report = make(map[string]map[string]int)
 }
 
-// Sends an telegram. Check out the "telegram" section of the "config.json" file.
-func send(body string, success bool) {
-	bot, err := tgbotapi.NewBotAPI(viper.GetString("user.telegram.token"))
-	if err != nil {
-		log.Println(err)
-		return
+// Gets the "schedule" section of the config file, jobs without "enabled" are enabled
+func getScheduleConfig() map[string]jobConfig {
+	config := make(map[string]jobConfig)
+	for _, key := range viper.AllKeys() {
+		if !strings.HasPrefix(key, "schedule.") || !strings.HasSuffix(key, ".spec") {
+			continue
+		}
+		key = strings.TrimSuffix(key, ".spec")
+		name := strings.TrimPrefix(key, "schedule.")
+		enabled := true
+		if viper.IsSet(key + ".enabled") {
+			enabled = viper.GetBool(key + ".enabled")
+		}
+		config[name] = jobConfig{
+			Spec:    viper.GetString(key + ".spec"),
+			Enabled: enabled,
+			Args:    viper.GetString(key + ".args"),
+		}
 	}
-
-	bot.Debug = false
-	log.Printf("Authorized on account %s", bot.Self.UserName)
-
-	msg := tgbotapi.NewMessage(viper.GetInt64("user.telegram.id"), body)
-	bot.Send(msg)
+	return config
 }
 
 // Retries the same function [function], a certain number of times (maxAttempts).
 // It is exponential : the 1st time it will be (sleep), the 2nd time, (sleep) x 2, the 3rd time, (sleep) x 3, etc.
-// If this function fails to recover after an error, it will send an email to the address in the config file.
+// If this function fails to recover after an error, admins get a fatal notification.
 func retry(maxAttempts int, sleep time.Duration, function func() error) (err error) {
 	for currentAttempt := 0; currentAttempt < maxAttempts; currentAttempt++ {
 		err = function()
@@ -170,10 +138,10 @@ func retry(maxAttempts int, sleep time.Duration, function func() error) (err err
 		for i := 0; i <= currentAttempt; i++ {
 			time.Sleep(sleep)
 		}
-		log.Println("Retrying after error:", err)
+		logger.WithError(err).Warn("retrying after error")
 	}
 
-	send(fmt.Sprintf("The script has stopped due to an unrecoverable error :\n%s", err), false)
+	notify(severityFatal, "", fmt.Sprintf("The script has stopped due to an unrecoverable error :\n%s", err))
 	return fmt.Errorf("After %d attempts, last error: %s", maxAttempts, err)
 }
 
@@ -249,3 +217,50 @@ func contains(slice []goinsta.User, user goinsta.User) bool {
 	}
 	return false
 }
+
+func removeString(list []string, a string) []string {
+	result := list[:0]
+	for _, b := range list {
+		if a != b {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// telegramMessageLimit keeps messages under the 4096 characters allowed by Telegram
+const telegramMessageLimit = 4000
+
+// splitMessage joins lines into texts of at most limit bytes, a longer line is cut on a rune boundary
+func splitMessage(lines []string, limit int) []string {
+	var texts []string
+	var chunk []string
+	size := 0
+	for _, line := range lines {
+		line = truncateText(line, limit)
+		if len(chunk) > 0 && size+1+len(line) > limit {
+			texts = append(texts, strings.Join(chunk, "\n"))
+			chunk, size = nil, 0
+		}
+		if len(chunk) > 0 {
+			size++
+		}
+		chunk = append(chunk, line)
+		size += len(line)
+	}
+	if len(chunk) > 0 {
+		texts = append(texts, strings.Join(chunk, "\n"))
+	}
+	return texts
+}
+
+// truncateText cuts text to at most limit bytes without splitting a UTF-8 rune
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit]
+}
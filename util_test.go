@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	lines := []string{"header", "", strings.Repeat("я", 6), "tail"}
+
+	texts := splitMessage(lines, 16)
+	want := []string{"header\n", strings.Repeat("я", 6), "tail"}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", texts, want)
+	}
+
+	for _, text := range splitMessage([]string{strings.Repeat("я", 10)}, 7) {
+		if !utf8.ValidString(text) || len(text) > 7 {
+			t.Errorf("%q is not cut on a rune boundary within 7 bytes", text)
+		}
+	}
+
+	if texts := splitMessage(lines, telegramMessageLimit); len(texts) != 1 || texts[0] != strings.Join(lines, "\n") {
+		t.Errorf("short report is split: %q", texts)
+	}
+}